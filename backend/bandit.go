@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// How auto-generated WorkerParams are chosen, set with HIVEMIND_PARAM_TUNING
+const (
+	ParamTuningRandom = "random"
+	ParamTuningBandit = "bandit"
+)
+
+// Share of bandit draws that ignore what has been learned, set with HIVEMIND_BANDIT_EXPLORATION
+const DefaultBanditExploration = 0.1
+
+// A human pick counts this many times as much as a master ranking
+const HumanRewardWeight = 2.0
+
+// Ranges generateWorkerParams draws from
+const (
+	MinTemperature = 0.3
+	MaxTemperature = 1.2
+	MinTopK        = 20
+	MaxTopK        = 80
+	MinTopP        = 0.7
+	MaxTopP        = 0.95
+)
+
+var (
+	paramTuning       = ParamTuningRandom
+	banditExploration = DefaultBanditExploration
+)
+
+type paramRange struct {
+	min float64
+	max float64
+}
+
+// Low, mid and high bands per parameter, matching paramBucket; each combination is one arm
+var (
+	temperatureBands = [3]paramRange{{MinTemperature, LowTemperatureMax}, {LowTemperatureMax, HighTemperatureMin}, {HighTemperatureMin, MaxTemperature}}
+	topKBands        = [3]paramRange{{MinTopK, LowTopKMax}, {LowTopKMax, HighTopKMin}, {HighTopKMin, MaxTopK + 1}}
+	topPBands        = [3]paramRange{{MinTopP, LowTopPMax}, {LowTopPMax, HighTopPMin}, {HighTopPMin, MaxTopP}}
+)
+
+const banditArms = 27
+
+// Beta posterior over how often an arm's responses win; starts uniform
+type armState struct {
+	alpha        float64
+	beta         float64
+	observations int
+}
+
+type ArmStats struct {
+	Bucket       string  `json:"bucket"`
+	Alpha        float64 `json:"alpha"`
+	Beta         float64 `json:"beta"`
+	Mean         float64 `json:"mean"`
+	Observations int     `json:"observations"`
+}
+
+type TuningReport struct {
+	Mode        string                `json:"mode"`
+	Exploration float64               `json:"exploration"`
+	Domains     map[string][]ArmStats `json:"domains"`
+}
+
+// Thompson sampling over parameter arms, one set of arms per query domain
+type paramTuner struct {
+	mu      sync.Mutex
+	domains map[string]*[banditArms]armState
+}
+
+var tuner = newParamTuner()
+
+func newParamTuner() *paramTuner {
+	return &paramTuner{domains: make(map[string]*[banditArms]armState)}
+}
+
+// WorkerParams for an agent the caller did not configure: drawn by the bandit when
+// tuning is enabled, uniformly at random otherwise
+func tunedWorkerParams(domain, workerID string) WorkerParams {
+	if paramTuning != ParamTuningBandit || rand.Float64() < banditExploration {
+		return generateWorkerParams(workerID)
+	}
+	return sampleArm(tuner.chooseArm(domain), workerID)
+}
+
+func armBands(arm int) (temperature, topK, topP paramRange) {
+	return temperatureBands[arm/9], topKBands[arm/3%3], topPBands[arm%3]
+}
+
+// Which arm a set of parameters falls in, so any result can reward its arm
+func armForParams(params WorkerParams) int {
+	band := func(value, lowMax, highMin float64) int {
+		switch {
+		case value < lowMax:
+			return 0
+		case value >= highMin:
+			return 2
+		}
+		return 1
+	}
+	return band(params.Temperature, LowTemperatureMax, HighTemperatureMin)*9 +
+		band(float64(params.TopK), LowTopKMax, HighTopKMin)*3 +
+		band(params.TopP, LowTopPMax, HighTopPMin)
+}
+
+func sampleArm(arm int, workerID string) WorkerParams {
+	temperature, topK, topP := armBands(arm)
+	return WorkerParams{
+		Temperature: temperature.min + rand.Float64()*(temperature.max-temperature.min),
+		TopK:        int(topK.min) + rand.Intn(int(topK.max-topK.min)),
+		TopP:        topP.min + rand.Float64()*(topP.max-topP.min),
+		WorkerID:    workerID,
+	}
+}
+
+// Draw from every arm's posterior and take the best draw
+func (t *paramTuner) chooseArm(domain string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	arms := t.arms(domain)
+	best, bestDraw := 0, -1.0
+	for arm := range arms {
+		if draw := sampleBeta(arms[arm].alpha, arms[arm].beta); draw > bestDraw {
+			best, bestDraw = arm, draw
+		}
+	}
+	return best
+}
+
+// Callers hold t.mu
+func (t *paramTuner) arms(domain string) *[banditArms]armState {
+	if t.domains[domain] == nil {
+		arms := &[banditArms]armState{}
+		for i := range arms {
+			arms[i] = armState{alpha: 1, beta: 1}
+		}
+		t.domains[domain] = arms
+	}
+	return t.domains[domain]
+}
+
+// Credit each result's arm with a reward in [0, 1], scaled by weight
+func (t *paramTuner) reward(domain string, params []WorkerParams, rewards map[int]float64, weight float64) {
+	if len(rewards) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	arms := t.arms(domain)
+	for index, reward := range rewards {
+		arm := &arms[armForParams(params[index])]
+		arm.alpha += weight * reward
+		arm.beta += weight * (1 - reward)
+		arm.observations++
+	}
+}
+
+// Parameters each result was produced with, in result order
+func resultParams(record *QueryRecord) []WorkerParams {
+	params := make([]WorkerParams, len(record.Response.Results))
+	for i, result := range record.Response.Results {
+		if result.WorkerParams != nil {
+			params[i] = *result.WorkerParams
+		} else if i < len(record.Request.Agents) {
+			params[i] = record.Request.Agents[i].WorkerParams
+		}
+	}
+	return params
+}
+
+// Share of the other valid responses each response beat in the master's ranking; ties count half
+func masterRewards(response *QueryResponse) map[int]float64 {
+	evaluation := response.MasterEvaluation
+	if evaluation == nil || evaluation.Strategy == StrategySingle {
+		return nil
+	}
+
+	scores := make(map[int]float64)
+	for _, ranking := range evaluation.Rankings {
+		if ranking.Index >= 0 && ranking.Index < len(response.Results) && response.Results[ranking.Index].Error == "" {
+			scores[ranking.Index] = ranking.Score
+		}
+	}
+	if len(scores) < 2 {
+		return nil
+	}
+
+	rewards := make(map[int]float64, len(scores))
+	for i, a := range scores {
+		beaten := 0.0
+		for j, b := range scores {
+			switch {
+			case i == j:
+			case a-b >= RankingTieMargin:
+				beaten++
+			case math.Abs(a-b) < RankingTieMargin:
+				beaten += 0.5
+			}
+		}
+		rewards[i] = beaten / float64(len(scores)-1)
+	}
+	return rewards
+}
+
+func (t *paramTuner) recordQuery(record *QueryRecord) {
+	if record.Response == nil || servedFromCache(record) {
+		return
+	}
+	t.reward(classifyDomain(record.Request), resultParams(record), masterRewards(record.Response), 1)
+}
+
+func (t *paramTuner) recordFeedback(feedback QueryFeedback, record *QueryRecord) {
+	if record.Response == nil {
+		return
+	}
+	t.rewardPick(classifyDomain(record.Request), resultParams(record), feedback.BestIndex)
+}
+
+// The user's pick earns a full reward and every other response none
+func (t *paramTuner) rewardPick(domain string, params []WorkerParams, best int) {
+	if len(params) < 2 || best < 0 || best >= len(params) {
+		return
+	}
+	rewards := make(map[int]float64, len(params))
+	for i := range params {
+		rewards[i] = 0
+	}
+	rewards[best] = 1
+	t.reward(domain, params, rewards, HumanRewardWeight)
+}
+
+// Re-learn from stored queries and feedback; Beta updates commute, so order does not matter
+func (t *paramTuner) rebuild(ctx context.Context, store Store) error {
+	type storedQuery struct {
+		domain string
+		params []WorkerParams
+	}
+
+	rebuilt := newParamTuner()
+	queries := make(map[string]storedQuery)
+	err := store.ScanQueries(ctx, func(record *QueryRecord) error {
+		if record.Response == nil {
+			return nil
+		}
+		rebuilt.recordQuery(record)
+		queries[record.QueryId] = storedQuery{domain: classifyDomain(record.Request), params: resultParams(record)}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	feedback, err := store.ListFeedback(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, entry := range feedback {
+		if query, ok := queries[entry.QueryId]; ok {
+			rebuilt.rewardPick(query.domain, query.params, entry.BestIndex)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.domains = rebuilt.domains
+	return nil
+}
+
+func (t *paramTuner) report() *TuningReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := &TuningReport{Mode: paramTuning, Exploration: banditExploration, Domains: make(map[string][]ArmStats)}
+	for domain, arms := range t.domains {
+		stats := make([]ArmStats, 0, banditArms)
+		for arm, state := range arms {
+			temperature, topK, topP := armBands(arm)
+			stats = append(stats, ArmStats{
+				Bucket:       paramBucket(WorkerParams{Temperature: temperature.min, TopK: int(topK.min), TopP: topP.min}),
+				Alpha:        state.alpha,
+				Beta:         state.beta,
+				Mean:         state.alpha / (state.alpha + state.beta),
+				Observations: state.observations,
+			})
+		}
+		sort.Slice(stats, func(i, j int) bool { return stats[i].Mean > stats[j].Mean })
+		report.Domains[domain] = stats
+	}
+	return report
+}
+
+func validateParamTuning(mode string, exploration float64) error {
+	if mode != ParamTuningRandom && mode != ParamTuningBandit {
+		return fmt.Errorf("unknown mode %q (use %s or %s)", mode, ParamTuningRandom, ParamTuningBandit)
+	}
+	if exploration < 0 || exploration > 1 {
+		return fmt.Errorf("exploration must be between 0 and 1, got %g", exploration)
+	}
+	return nil
+}
+
+// Beta(a, b) as the ratio of two gamma draws
+func sampleBeta(a, b float64) float64 {
+	x := sampleGamma(a)
+	y := sampleGamma(b)
+	if x+y == 0 {
+		return 0.5
+	}
+	return x / (x + y)
+}
+
+// Marsaglia and Tsang's method; shapes below 1 are boosted and corrected
+func sampleGamma(shape float64) float64 {
+	if shape < 1 {
+		return sampleGamma(shape+1) * math.Pow(rand.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rand.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rand.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
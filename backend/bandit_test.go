@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestArmForParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params WorkerParams
+		want   int
+	}{
+		{"all low", WorkerParams{Temperature: 0.3, TopK: 20, TopP: 0.7}, 0},
+		{"all high", WorkerParams{Temperature: 1.2, TopK: 80, TopP: 0.95}, 26},
+		{"all medium", WorkerParams{Temperature: 0.7, TopK: 45, TopP: 0.85}, 13},
+		{"band edges belong to the upper band", WorkerParams{Temperature: 0.5, TopK: 60, TopP: 0.8}, 1*9 + 2*3 + 1},
+		{"just below the edges", WorkerParams{Temperature: 0.49, TopK: 34, TopP: 0.79}, 0},
+		{"temperature dominates", WorkerParams{Temperature: 1.0, TopK: 20, TopP: 0.7}, 18},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := armForParams(tt.params); got != tt.want {
+				t.Errorf("armForParams() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSampleArmStaysInArm(t *testing.T) {
+	for arm := 0; arm < 27; arm++ {
+		for i := 0; i < 50; i++ {
+			params := sampleArm(arm, "Worker-1")
+			if got := armForParams(params); got != arm {
+				t.Fatalf("sampleArm(%d) drew %+v, which maps to arm %d", arm, params, got)
+			}
+		}
+	}
+}
+
+func TestSampleBeta(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b float64
+	}{
+		{"uniform", 1, 1},
+		{"skewed high", 20, 2},
+		{"skewed low", 2, 20},
+		{"shapes below one", 0.5, 0.5},
+	}
+
+	const draws = 20000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum := 0.0
+			for i := 0; i < draws; i++ {
+				x := sampleBeta(tt.a, tt.b)
+				if x < 0 || x > 1 || math.IsNaN(x) {
+					t.Fatalf("sampleBeta(%g, %g) = %g, outside [0, 1]", tt.a, tt.b, x)
+				}
+				sum += x
+			}
+			want := tt.a / (tt.a + tt.b)
+			if mean := sum / draws; math.Abs(mean-want) > 0.02 {
+				t.Errorf("mean of %d draws = %.3f, want about %.3f", draws, mean, want)
+			}
+		})
+	}
+}
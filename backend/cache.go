@@ -0,0 +1,337 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How /query reuses earlier responses, set with HIVEMIND_CACHE
+const (
+	CacheOff      = "off"
+	CacheExact    = "exact"
+	CacheSemantic = "semantic"
+)
+
+// Defaults for HIVEMIND_CACHE_TTL, HIVEMIND_CACHE_MAX_ENTRIES and HIVEMIND_CACHE_SIMILARITY.
+// Embeddings barely separate queries that differ in one number or name ("what is
+// 17*23" and "what is 17*24" can score above 0.95), so semantic hits need a high threshold
+// and remain unsafe for arithmetic and lookups of specific values.
+const (
+	DefaultCacheTTL        = time.Hour
+	DefaultCacheMaxEntries = 256
+	DefaultCacheSimilarity = 0.98
+)
+
+// Time allowed to embed a query when storing it for semantic lookups
+const CacheEmbeddingTimeout = 15 * time.Second
+
+// Caching is opt-in: agent teams are randomized, so a cached answer is a frozen sample
+var (
+	cacheMode       = CacheOff
+	cacheTTL        = DefaultCacheTTL
+	cacheMaxEntries = DefaultCacheMaxEntries
+	cacheSimilarity = DefaultCacheSimilarity
+)
+
+// Attached to a QueryResponse served from the cache
+type CacheInfo struct {
+	Hit           bool      `json:"hit"`
+	Mode          string    `json:"mode"`
+	SourceQueryId string    `json:"sourceQueryId"`
+	CachedAt      time.Time `json:"cachedAt"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+
+	// Semantic hits only: the cached query that matched and how close it was
+	CachedQuery string  `json:"cachedQuery,omitempty"`
+	Similarity  float64 `json:"similarity,omitempty"`
+}
+
+type CacheStats struct {
+	Mode                string  `json:"mode"`
+	TTLSeconds          float64 `json:"ttlSeconds"`
+	MaxEntries          int     `json:"maxEntries"`
+	SimilarityThreshold float64 `json:"similarityThreshold,omitempty"`
+	Entries             int     `json:"entries"`
+	ExactHits           int     `json:"exactHits"`
+	SemanticHits        int     `json:"semanticHits"`
+	Misses              int     `json:"misses"`
+	Bypassed            int     `json:"bypassed"`
+	Evictions           int     `json:"evictions"`
+}
+
+// Keys for one request: configKey covers everything but the query text, so
+// semantic lookups only compare queries asked of the same team and settings
+type cacheLookup struct {
+	key       string
+	configKey string
+	query     string
+	embedding []float64
+}
+
+type cacheEntry struct {
+	cacheLookup
+	queryId   string
+	createdAt time.Time
+	expiresAt time.Time
+
+	// JSON snapshot so callers can never mutate what is cached
+	response []byte
+}
+
+// LRU of recent responses, most recently used at the front
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	stats   CacheStats
+}
+
+var queryCache = newResponseCache()
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// Case and spacing do not change the question, except inside code where whitespace can matter
+func normalizeQuery(query string) string {
+	query = strings.TrimSpace(query)
+	if strings.Contains(query, "```") {
+		return query
+	}
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
+
+// Requests whose responses may be cached. Auto-tuned parameters are left out so
+// the bandit keeps getting fresh draws to learn from.
+func cacheable(req QueryRequest) bool {
+	return cacheMode != CacheOff && !req.AutoParams
+}
+
+func newCacheLookup(req QueryRequest) *cacheLookup {
+	config := req
+	config.Query = ""
+	config.NoCache = false
+	encoded, _ := json.Marshal(struct {
+		Model   string       `json:"model"`
+		Request QueryRequest `json:"request"`
+	}{QwenModel, config})
+
+	lookup := &cacheLookup{configKey: hashKey(string(encoded)), query: normalizeQuery(req.Query)}
+	lookup.key = hashKey(lookup.configKey + "\n" + lookup.query)
+	return lookup
+}
+
+func hashKey(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// A cached response for the request, if any. The lookup is returned even on a miss
+// (nil when the request is not cacheable) so put can store the fresh response.
+func (c *responseCache) get(ctx context.Context, req QueryRequest) (*QueryResponse, *cacheLookup) {
+	if !cacheable(req) {
+		return nil, nil
+	}
+	lookup := newCacheLookup(req)
+	if req.NoCache {
+		c.mu.Lock()
+		c.stats.Bypassed++
+		c.mu.Unlock()
+		return nil, lookup
+	}
+
+	c.mu.Lock()
+	if entry := c.live(lookup.key); entry != nil {
+		c.stats.ExactHits++
+		c.mu.Unlock()
+		return entry.hit(CacheExact, 0), lookup
+	}
+	candidates := c.semanticCandidates(lookup.configKey)
+	c.mu.Unlock()
+
+	if cacheMode == CacheSemantic {
+		c.embed(ctx, lookup)
+		var best *cacheEntry
+		bestSimilarity := 0.0
+		for _, entry := range candidates {
+			if similarity := cosineSimilarity(lookup.embedding, entry.embedding); similarity > bestSimilarity {
+				best, bestSimilarity = entry, similarity
+			}
+		}
+		if best != nil && bestSimilarity >= cacheSimilarity {
+			c.mu.Lock()
+			c.stats.SemanticHits++
+			if element, ok := c.entries[best.key]; ok {
+				c.order.MoveToFront(element)
+			}
+			c.mu.Unlock()
+			return best.hit(CacheSemantic, bestSimilarity), lookup
+		}
+	}
+
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+	return nil, lookup
+}
+
+// Store a freshly generated response. Responses with failed agents are not kept,
+// since a retry may well succeed.
+func (c *responseCache) put(lookup *cacheLookup, response *QueryResponse) {
+	if lookup == nil || len(response.Results) == 0 {
+		return
+	}
+	for _, result := range response.Results {
+		if result.Error != "" {
+			return
+		}
+	}
+
+	if cacheMode == CacheSemantic && lookup.embedding == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), CacheEmbeddingTimeout)
+		c.embed(ctx, lookup)
+		cancel()
+	}
+	snapshot, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Failed to cache query %s: %v", response.QueryId, err)
+		return
+	}
+
+	now := time.Now()
+	entry := &cacheEntry{
+		cacheLookup: *lookup,
+		queryId:     response.QueryId,
+		createdAt:   now,
+		expiresAt:   now.Add(cacheTTL),
+		response:    snapshot,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[lookup.key]; ok {
+		c.order.Remove(element)
+	}
+	c.entries[lookup.key] = c.order.PushFront(entry)
+	for c.order.Len() > cacheMaxEntries {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Embed the query for semantic comparison; without an embedding only exact hits are possible
+func (c *responseCache) embed(ctx context.Context, lookup *cacheLookup) {
+	if lookup.embedding != nil {
+		return
+	}
+	embeddings, err := fetchEmbeddings(ctx, []string{lookup.query})
+	if err != nil || len(embeddings) != 1 {
+		log.Printf("Semantic cache lookup unavailable: %v", err)
+		return
+	}
+	lookup.embedding = embeddings[0]
+}
+
+// The unexpired entry for key, moved to the front; callers hold c.mu
+func (c *responseCache) live(key string) *cacheEntry {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.remove(element)
+		return nil
+	}
+	c.order.MoveToFront(element)
+	return entry
+}
+
+// Unexpired, embedded entries for the same configuration; callers hold c.mu
+func (c *responseCache) semanticCandidates(configKey string) []*cacheEntry {
+	if cacheMode != CacheSemantic {
+		return nil
+	}
+	now := time.Now()
+	candidates := make([]*cacheEntry, 0)
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*cacheEntry)
+		if entry.configKey == configKey && entry.embedding != nil && now.Before(entry.expiresAt) {
+			candidates = append(candidates, entry)
+		}
+	}
+	return candidates
+}
+
+// Callers hold c.mu
+func (c *responseCache) remove(element *list.Element) {
+	delete(c.entries, element.Value.(*cacheEntry).key)
+	c.order.Remove(element)
+}
+
+// A fresh copy of the cached response marked as a hit. No model calls were made,
+// so it reports no token usage.
+func (e *cacheEntry) hit(mode string, similarity float64) *QueryResponse {
+	var response QueryResponse
+	if err := json.Unmarshal(e.response, &response); err != nil {
+		return nil
+	}
+	response.Usage = nil
+	response.Cache = &CacheInfo{
+		Hit:           true,
+		Mode:          mode,
+		SourceQueryId: e.queryId,
+		CachedAt:      e.createdAt,
+		ExpiresAt:     e.expiresAt,
+	}
+	if mode == CacheSemantic {
+		response.Cache.CachedQuery = e.query
+		response.Cache.Similarity = similarity
+	}
+	return &response
+}
+
+func (c *responseCache) report() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Mode = cacheMode
+	stats.TTLSeconds = cacheTTL.Seconds()
+	stats.MaxEntries = cacheMaxEntries
+	if cacheMode == CacheSemantic {
+		stats.SimilarityThreshold = cacheSimilarity
+	}
+	stats.Entries = c.order.Len()
+	return stats
+}
+
+// Stored records served from the cache repeat an earlier query's outcome and
+// must not be counted again by the leaderboard, tuner or exports
+func servedFromCache(record *QueryRecord) bool {
+	return record.Response != nil && record.Response.Cache != nil
+}
+
+func validateCacheConfig(mode string, ttl time.Duration, maxEntries int, similarity float64) error {
+	if mode != CacheOff && mode != CacheExact && mode != CacheSemantic {
+		return fmt.Errorf("unknown mode %q (use %s, %s or %s)", mode, CacheOff, CacheExact, CacheSemantic)
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("TTL must be positive, got %v", ttl)
+	}
+	if maxEntries < 1 {
+		return fmt.Errorf("max entries must be at least 1, got %d", maxEntries)
+	}
+	if similarity <= 0 || similarity > 1 {
+		return fmt.Errorf("similarity threshold must be in (0, 1], got %g", similarity)
+	}
+	return nil
+}
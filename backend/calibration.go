@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// How many recent judge decisions can still receive human votes
+const MaxTrackedDecisions = 10000
+
+// Rubric recorded for votes that do not name one
+const DefaultRubric = "overall"
+
+var (
+	errUnknownQuery = errors.New("unknown query")
+	errInvalidVote  = errors.New("invalid vote")
+)
+
+// What the master decided for a query, kept so human picks can be compared with it
+type JudgeDecision struct {
+	QueryId         string    `json:"queryId"`
+	MasterBestIndex int       `json:"masterBestIndex"`
+	Candidates      int       `json:"candidates"`
+	Strategy        string    `json:"strategy"`
+	JudgeModel      string    `json:"judgeModel"`
+	ClusterJudging  bool      `json:"clusterJudging,omitempty"`
+	CachedFrom      string    `json:"cachedFrom,omitempty"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// A human's pick for the best response to a query
+type HumanVote struct {
+	QueryId   string    `json:"queryId"`
+	BestIndex int       `json:"bestIndex"`
+	Rubric    string    `json:"rubric,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// A human vote together with the judge decision it is compared against
+type CalibrationVote struct {
+	HumanVote
+	Decision JudgeDecision `json:"decision"`
+	Agrees   bool          `json:"agrees"`
+}
+
+// Agreement between master and human picks. Kappa corrects for agreement a uniformly
+// random judge would reach; the interval is a 95% Wilson score interval on the rate.
+type AgreementStats struct {
+	Votes           int     `json:"votes"`
+	Agreements      int     `json:"agreements"`
+	MasterAbstained int     `json:"masterAbstained"`
+	AgreementRate   float64 `json:"agreementRate"`
+	IntervalLow     float64 `json:"intervalLow"`
+	IntervalHigh    float64 `json:"intervalHigh"`
+	ChanceAgreement float64 `json:"chanceAgreement"`
+	Kappa           float64 `json:"kappa"`
+
+	chanceTotal float64
+}
+
+type CalibrationReport struct {
+	Overall      *AgreementStats            `json:"overall"`
+	ByRubric     map[string]*AgreementStats `json:"byRubric"`
+	ByJudgeModel map[string]*AgreementStats `json:"byJudgeModel"`
+	ByStrategy   map[string]*AgreementStats `json:"byStrategy"`
+	GeneratedAt  time.Time                  `json:"generatedAt"`
+}
+
+type calibrationStore struct {
+	mu        sync.Mutex
+	decisions map[string]JudgeDecision
+	order     []string
+	votes     []CalibrationVote
+}
+
+var calibration = &calibrationStore{decisions: make(map[string]JudgeDecision)}
+
+func newJudgeDecision(req QueryRequest, response *QueryResponse) JudgeDecision {
+	decision := JudgeDecision{
+		QueryId:         response.QueryId,
+		MasterBestIndex: -1,
+		Candidates:      len(response.Results),
+		ClusterJudging:  req.ClusterJudging,
+		Timestamp:       time.Now(),
+	}
+	if response.Cache != nil {
+		decision.CachedFrom = response.Cache.SourceQueryId
+	}
+	if evaluation := response.MasterEvaluation; evaluation != nil {
+		decision.MasterBestIndex = evaluation.BestResponseIndex
+		decision.Strategy = evaluation.Strategy
+		decision.JudgeModel = evaluation.JudgeModel
+	}
+	if decision.JudgeModel == "" {
+		decision.JudgeModel = "none"
+	}
+	return decision
+}
+
+func (s *calibrationStore) recordDecision(decision JudgeDecision) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.decisions[decision.QueryId]; !exists {
+		s.order = append(s.order, decision.QueryId)
+	}
+	s.decisions[decision.QueryId] = decision
+
+	for len(s.order) > MaxTrackedDecisions {
+		delete(s.decisions, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Compare a human pick with the stored decision for its query, falling back to the
+// persisted query when the decision is no longer in memory. Votes on cache hits are
+// rejected either way.
+func (s *calibrationStore) recordVote(ctx context.Context, vote HumanVote) (CalibrationVote, error) {
+	s.mu.Lock()
+	decision, ok := s.decisions[vote.QueryId]
+	s.mu.Unlock()
+
+	if !ok {
+		record, err := store.GetQuery(ctx, vote.QueryId)
+		if errors.Is(err, errNotFound) || (err == nil && record.Response == nil) {
+			return CalibrationVote{}, fmt.Errorf("%w %q", errUnknownQuery, vote.QueryId)
+		}
+		if err != nil {
+			return CalibrationVote{}, fmt.Errorf("loading query %q: %v", vote.QueryId, err)
+		}
+		decision = newJudgeDecision(record.Request, record.Response)
+		decision.Timestamp = record.CreatedAt
+	}
+	// A cache hit repeats another query's pick; counting votes on both would count that pick twice
+	if decision.CachedFrom != "" {
+		return CalibrationVote{}, fmt.Errorf("%w: query %q was answered from the cache, vote on the original query %q instead", errInvalidVote, vote.QueryId, decision.CachedFrom)
+	}
+	if vote.BestIndex < 0 || vote.BestIndex >= decision.Candidates {
+		return CalibrationVote{}, fmt.Errorf("%w: bestIndex %d is out of range for %d responses", errInvalidVote, vote.BestIndex, decision.Candidates)
+	}
+	if vote.Rubric == "" {
+		vote.Rubric = DefaultRubric
+	}
+	if vote.Timestamp.IsZero() {
+		vote.Timestamp = time.Now()
+	}
+
+	recorded := CalibrationVote{
+		HumanVote: vote,
+		Decision:  decision,
+		Agrees:    decision.MasterBestIndex == vote.BestIndex,
+	}
+	s.mu.Lock()
+	s.votes = dedupeVotes(append(s.votes, recorded))
+	s.mu.Unlock()
+	return recorded, nil
+}
+
+// Keep one vote per query and rubric, the latest, so a pick sent to both
+// POST /calibration/votes and POST /queries/:id/feedback counts once
+func dedupeVotes(votes []CalibrationVote) []CalibrationVote {
+	type voteKey struct{ queryId, rubric string }
+	latest := make(map[voteKey]int, len(votes))
+	for i, vote := range votes {
+		latest[voteKey{vote.QueryId, vote.Rubric}] = i
+	}
+
+	kept := make([]CalibrationVote, 0, len(latest))
+	for i, vote := range votes {
+		if latest[voteKey{vote.QueryId, vote.Rubric}] == i {
+			kept = append(kept, vote)
+		}
+	}
+	return kept
+}
+
+// Restore votes persisted by earlier runs so the report survives restarts
+func (s *calibrationStore) loadVotes(store Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), StorageTimeout)
+	defer cancel()
+
+	votes, err := store.ListVotes(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.votes = dedupeVotes(append(votes, s.votes...))
+	return nil
+}
+
+func (s *calibrationStore) report() *CalibrationReport {
+	s.mu.Lock()
+	votes := append([]CalibrationVote{}, s.votes...)
+	s.mu.Unlock()
+
+	return buildCalibrationReport(votes)
+}
+
+func buildCalibrationReport(votes []CalibrationVote) *CalibrationReport {
+	report := &CalibrationReport{
+		Overall:      &AgreementStats{},
+		ByRubric:     make(map[string]*AgreementStats),
+		ByJudgeModel: make(map[string]*AgreementStats),
+		ByStrategy:   make(map[string]*AgreementStats),
+		GeneratedAt:  time.Now(),
+	}
+
+	group := func(groups map[string]*AgreementStats, key string) *AgreementStats {
+		if key == "" {
+			key = "unknown"
+		}
+		if groups[key] == nil {
+			groups[key] = &AgreementStats{}
+		}
+		return groups[key]
+	}
+
+	for _, vote := range votes {
+		for _, stats := range []*AgreementStats{
+			report.Overall,
+			group(report.ByRubric, vote.Rubric),
+			group(report.ByJudgeModel, vote.Decision.JudgeModel),
+			group(report.ByStrategy, vote.Decision.Strategy),
+		} {
+			stats.add(vote)
+		}
+	}
+
+	report.Overall.finish()
+	for _, groups := range []map[string]*AgreementStats{report.ByRubric, report.ByJudgeModel, report.ByStrategy} {
+		for _, stats := range groups {
+			stats.finish()
+		}
+	}
+	return report
+}
+
+func (a *AgreementStats) add(vote CalibrationVote) {
+	a.Votes++
+	if vote.Agrees {
+		a.Agreements++
+	}
+	if vote.Decision.MasterBestIndex < 0 {
+		a.MasterAbstained++
+	}
+	if vote.Decision.Candidates > 0 {
+		a.chanceTotal += 1 / float64(vote.Decision.Candidates)
+	}
+}
+
+func (a *AgreementStats) finish() {
+	if a.Votes == 0 {
+		return
+	}
+	n := float64(a.Votes)
+	a.AgreementRate = float64(a.Agreements) / n
+	a.ChanceAgreement = a.chanceTotal / n
+	if a.ChanceAgreement < 1 {
+		a.Kappa = (a.AgreementRate - a.ChanceAgreement) / (1 - a.ChanceAgreement)
+	}
+	a.IntervalLow, a.IntervalHigh = wilsonInterval(a.Agreements, a.Votes)
+}
+
+// 95% Wilson score interval for a binomial proportion
+func wilsonInterval(successes, trials int) (float64, float64) {
+	if trials == 0 {
+		return 0, 0
+	}
+	const z = 1.96
+	n := float64(trials)
+	p := float64(successes) / n
+	denominator := 1 + z*z/n
+	center := (p + z*z/(2*n)) / denominator
+	margin := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n)) / denominator
+	return math.Max(0, center-margin), math.Min(1, center+margin)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func testVote(rubric string, masterBest, humanBest, candidates int) CalibrationVote {
+	return CalibrationVote{
+		HumanVote: HumanVote{QueryId: "q", BestIndex: humanBest, Rubric: rubric},
+		Decision:  JudgeDecision{MasterBestIndex: masterBest, Candidates: candidates, Strategy: StrategyJudge, JudgeModel: "judge"},
+		Agrees:    masterBest == humanBest,
+	}
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestBuildCalibrationReport(t *testing.T) {
+	tests := []struct {
+		name          string
+		votes         []CalibrationVote
+		wantVotes     int
+		wantAgree     int
+		wantAbstained int
+		wantRate      float64
+		wantChance    float64
+		wantKappa     float64
+	}{
+		{"zero votes", nil, 0, 0, 0, 0, 0, 0},
+		{"all agree", []CalibrationVote{testVote("overall", 1, 1, 4), testVote("overall", 0, 0, 4)}, 2, 2, 0, 1, 0.25, 1},
+		{"chance-level agreement", []CalibrationVote{testVote("overall", 0, 0, 2), testVote("overall", 0, 1, 2)}, 2, 1, 0, 0.5, 0.5, 0},
+		{"mixed candidate counts", []CalibrationVote{testVote("overall", 2, 2, 4), testVote("overall", 0, 1, 2)}, 2, 1, 0, 0.5, 0.375, 0.2},
+		{"master abstained", []CalibrationVote{testVote("overall", -1, 0, 4), testVote("overall", 3, 3, 4)}, 2, 1, 1, 0.5, 0.25, 1.0 / 3},
+		{"single candidate has no chance correction", []CalibrationVote{testVote("overall", 0, 0, 1)}, 1, 1, 0, 1, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overall := buildCalibrationReport(tt.votes).Overall
+			if overall.Votes != tt.wantVotes || overall.Agreements != tt.wantAgree || overall.MasterAbstained != tt.wantAbstained {
+				t.Errorf("votes/agreements/abstained = %d/%d/%d, want %d/%d/%d",
+					overall.Votes, overall.Agreements, overall.MasterAbstained, tt.wantVotes, tt.wantAgree, tt.wantAbstained)
+			}
+			if !approxEqual(overall.AgreementRate, tt.wantRate) {
+				t.Errorf("agreement rate = %v, want %v", overall.AgreementRate, tt.wantRate)
+			}
+			if !approxEqual(overall.ChanceAgreement, tt.wantChance) {
+				t.Errorf("chance agreement = %v, want %v", overall.ChanceAgreement, tt.wantChance)
+			}
+			if !approxEqual(overall.Kappa, tt.wantKappa) {
+				t.Errorf("kappa = %v, want %v", overall.Kappa, tt.wantKappa)
+			}
+		})
+	}
+}
+
+func TestBuildCalibrationReportGroups(t *testing.T) {
+	votes := []CalibrationVote{testVote("accuracy", 0, 0, 2), testVote("style", 0, 1, 2), testVote("style", 1, 1, 2)}
+	votes[2].Decision.Strategy = ""
+
+	report := buildCalibrationReport(votes)
+	if got := report.ByRubric["accuracy"]; got == nil || got.Votes != 1 || got.Agreements != 1 {
+		t.Errorf("accuracy = %+v, want 1 agreeing vote", got)
+	}
+	if got := report.ByRubric["style"]; got == nil || got.Votes != 2 || got.Agreements != 1 {
+		t.Errorf("style = %+v, want 2 votes with 1 agreement", got)
+	}
+	if got := report.ByJudgeModel["judge"]; got == nil || got.Votes != 3 {
+		t.Errorf("judge model = %+v, want all 3 votes", got)
+	}
+	if got := report.ByStrategy["unknown"]; got == nil || got.Votes != 1 {
+		t.Errorf("unknown strategy = %+v, want the vote without a strategy", got)
+	}
+}
+
+func TestWilsonInterval(t *testing.T) {
+	tests := []struct {
+		name      string
+		successes int
+		trials    int
+		wantLow   float64
+		wantHigh  float64
+	}{
+		{"no trials", 0, 0, 0, 0},
+		{"eight of ten", 8, 10, 0.4901568467, 0.9433190520},
+		{"none of five", 0, 5, 0, 0.4344914948},
+		{"all of five", 5, 5, 0.5655085052, 1},
+		{"one of two", 1, 2, 0.0945286548, 0.9054713452},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			low, high := wilsonInterval(tt.successes, tt.trials)
+			if math.Abs(low-tt.wantLow) > 1e-9 || math.Abs(high-tt.wantHigh) > 1e-9 {
+				t.Errorf("wilsonInterval(%d, %d) = [%.10f, %.10f], want [%.10f, %.10f]",
+					tt.successes, tt.trials, low, high, tt.wantLow, tt.wantHigh)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A family of responses that give essentially the same answer
+type ResponseCluster struct {
+	ID             int     `json:"id"`
+	Representative int     `json:"representative"`
+	Members        []int   `json:"members"`
+	Size           int     `json:"size"`
+	Cohesion       float64 `json:"cohesion"`
+}
+
+// Minimum average similarity for two groups of responses to be merged
+func clusterThreshold(method string) float64 {
+	if method == SimilarityLexical {
+		return ClusterThresholdLexical
+	}
+	return ClusterThresholdEmbedding
+}
+
+// Group usable responses into answer families using average-linkage
+// agglomerative clustering over the similarity matrix
+func clusterResponses(responses []AIResult, similarity *ResponseSimilarity) []ResponseCluster {
+	if similarity == nil {
+		return nil
+	}
+
+	groups := make([][]int, 0, len(responses))
+	for i := range responses {
+		if similarity.Matrix[i][i] > 0 {
+			groups = append(groups, []int{i})
+		}
+	}
+
+	if len(groups) == 0 {
+		return nil
+	}
+
+	threshold := clusterThreshold(similarity.Method)
+	for len(groups) > 1 {
+		bestA, bestB := -1, -1
+		bestLink := -1.0
+		for a := 0; a < len(groups); a++ {
+			for b := a + 1; b < len(groups); b++ {
+				link := similarity.averageLinkage(groups[a], groups[b])
+				if link > bestLink {
+					bestA, bestB, bestLink = a, b, link
+				}
+			}
+		}
+
+		if bestLink < threshold {
+			break
+		}
+
+		merged := append(append([]int{}, groups[bestA]...), groups[bestB]...)
+		sort.Ints(merged)
+		groups[bestA] = merged
+		groups = append(groups[:bestB], groups[bestB+1:]...)
+	}
+
+	clusters := make([]ResponseCluster, len(groups))
+	for i, members := range groups {
+		representative, cohesion := similarity.medoid(members)
+		clusters[i] = ResponseCluster{
+			Representative: representative,
+			Members:        members,
+			Size:           len(members),
+			Cohesion:       cohesion,
+		}
+	}
+
+	// Largest families first, ties broken by earliest representative
+	sort.SliceStable(clusters, func(i, j int) bool {
+		if clusters[i].Size != clusters[j].Size {
+			return clusters[i].Size > clusters[j].Size
+		}
+		return clusters[i].Representative < clusters[j].Representative
+	})
+	for i := range clusters {
+		clusters[i].ID = i + 1
+	}
+
+	return clusters
+}
+
+// Average pairwise similarity between members of two groups
+func (s *ResponseSimilarity) averageLinkage(a, b []int) float64 {
+	total := 0.0
+	for _, i := range a {
+		for _, j := range b {
+			total += s.Matrix[i][j]
+		}
+	}
+	return total / float64(len(a)*len(b))
+}
+
+// Member most similar to the rest of its group, and the group's mean internal similarity
+func (s *ResponseSimilarity) medoid(members []int) (int, float64) {
+	if len(members) == 1 {
+		return members[0], 1.0
+	}
+
+	best := members[0]
+	bestTotal := -1.0
+	groupTotal := 0.0
+	for _, i := range members {
+		total := 0.0
+		for _, j := range members {
+			if i != j {
+				total += s.Matrix[i][j]
+			}
+		}
+		groupTotal += total
+		if total > bestTotal {
+			best, bestTotal = i, total
+		}
+	}
+
+	pairs := float64(len(members) * (len(members) - 1))
+	return best, groupTotal / pairs
+}
+
+// Find the cluster a response belongs to
+func clusterForResponse(clusters []ResponseCluster, index int) *ResponseCluster {
+	for i := range clusters {
+		for _, member := range clusters[i].Members {
+			if member == index {
+				return &clusters[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Give every cluster member its representative's score, listed right after the representative
+func expandClusterRankings(rankings []ResponseRanking, clusters []ResponseCluster) []ResponseRanking {
+	expanded := make([]ResponseRanking, 0, len(rankings))
+	for _, ranking := range rankings {
+		expanded = append(expanded, ranking)
+
+		cluster := clusterForResponse(clusters, ranking.Index)
+		if cluster == nil || cluster.Representative != ranking.Index {
+			continue
+		}
+		for _, member := range cluster.Members {
+			if member == ranking.Index {
+				continue
+			}
+			expanded = append(expanded, ResponseRanking{
+				Index:     member,
+				Score:     ranking.Score,
+				Reasoning: fmt.Sprintf("Same answer family as response %d (cluster %d)", ranking.Index+1, cluster.ID),
+			})
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+)
+
+// Confidence methods reported on AIResult
+const (
+	ConfidenceLogprobs  = "logprobs"
+	ConfidenceHeuristic = "heuristic"
+)
+
+// Number of tokens in the sliding window used to find the least certain span
+const LogprobSpanWindow = 5
+
+// Per-token log-probabilities as returned by OpenAI-compatible chat completions
+type TokenLogprob struct {
+	Token       string  `json:"token"`
+	Logprob     float64 `json:"logprob"`
+	TopLogprobs []struct {
+		Token   string  `json:"token"`
+		Logprob float64 `json:"logprob"`
+	} `json:"top_logprobs,omitempty"`
+}
+
+// Sequence-level statistics behind a logprob-derived confidence
+type ConfidenceDetails struct {
+	MeanLogprob float64 `json:"meanLogprob"`
+	MeanProb    float64 `json:"meanProb"`
+	MinSpanProb float64 `json:"minSpanProb"`
+	MeanEntropy float64 `json:"meanEntropy"`
+	TokenCount  int     `json:"tokenCount"`
+}
+
+// Calculate confidence from token log-probabilities. Returns false when the
+// provider did not return usable logprobs.
+func calculateLogprobConfidence(tokens []TokenLogprob) (float64, *ConfidenceDetails, bool) {
+	if len(tokens) == 0 {
+		return 0.0, nil, false
+	}
+
+	details := &ConfidenceDetails{TokenCount: len(tokens)}
+
+	// Mean token logprob; exp of it is the geometric mean token probability
+	probs := make([]float64, len(tokens))
+	sum := 0.0
+	for i, token := range tokens {
+		if math.IsNaN(token.Logprob) || math.IsInf(token.Logprob, 1) {
+			return 0.0, nil, false
+		}
+		sum += token.Logprob
+		probs[i] = math.Exp(token.Logprob)
+	}
+	details.MeanLogprob = sum / float64(len(tokens))
+	details.MeanProb = math.Exp(details.MeanLogprob)
+
+	// Least certain span: lowest average probability over a sliding window
+	window := LogprobSpanWindow
+	if window > len(probs) {
+		window = len(probs)
+	}
+	spanSum := 0.0
+	for i := 0; i < window; i++ {
+		spanSum += probs[i]
+	}
+	details.MinSpanProb = spanSum / float64(window)
+	for i := window; i < len(probs); i++ {
+		spanSum += probs[i] - probs[i-window]
+		details.MinSpanProb = math.Min(details.MinSpanProb, spanSum/float64(window))
+	}
+
+	// Normalized entropy over each token's top alternatives (0 = certain, 1 = uniform)
+	entropyTotal := 0.0
+	entropyTokens := 0
+	for _, token := range tokens {
+		if len(token.TopLogprobs) < 2 {
+			continue
+		}
+		mass := 0.0
+		for _, alt := range token.TopLogprobs {
+			mass += math.Exp(alt.Logprob)
+		}
+		if mass <= 0 {
+			continue
+		}
+		entropy := 0.0
+		for _, alt := range token.TopLogprobs {
+			p := math.Exp(alt.Logprob) / mass
+			if p > 0 {
+				entropy -= p * math.Log(p)
+			}
+		}
+		entropyTotal += entropy / math.Log(float64(len(token.TopLogprobs)))
+		entropyTokens++
+	}
+	if entropyTokens > 0 {
+		details.MeanEntropy = entropyTotal / float64(entropyTokens)
+	}
+
+	// Weighted blend: overall likelihood, weakest span, and how decisive each choice was
+	confidence := details.MeanProb*0.5 + details.MinSpanProb*0.25 + (1.0-details.MeanEntropy)*0.25
+	if entropyTokens == 0 {
+		// No alternatives returned, so entropy is unknown rather than zero
+		confidence = details.MeanProb*0.65 + details.MinSpanProb*0.35
+	}
+
+	return math.Max(0.0, math.Min(1.0, confidence)), details, true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Coarse query domains used to break down ratings and tuning
+const (
+	DomainCode       = "code"
+	DomainMath       = "math"
+	DomainStructured = "structured"
+	DomainWriting    = "writing"
+	DomainGeneral    = "general"
+)
+
+var (
+	codeQueryPattern    = regexp.MustCompile(`(?i)\b(code|function|program|script|implement|debug|compile|regex|sql|api|golang|python|javascript|typescript|java|rust|c\+\+|algorithm|refactor|stack trace)\b`)
+	mathQueryPattern    = regexp.MustCompile(`(?i)\b(calculate|compute|solve|equation|integral|derivative|probability|sum of|how many|what is \d|prove|theorem)\b|\d+\s*[-+*/^%]\s*\d+`)
+	writingQueryPattern = regexp.MustCompile(`(?i)\b(write|draft|compose|rewrite|poem|story|essay|email|letter|slogan|tweet|blog|summari[sz]e|translate)\b`)
+)
+
+// Pick a domain from the request: explicit signals (test cases, schemas, numeric
+// grading) first, then keywords in the query text
+func classifyDomain(req QueryRequest) string {
+	switch {
+	case len(req.TestCases) > 0:
+		return DomainCode
+	case len(req.JSONSchema) > 0:
+		return DomainStructured
+	case req.GradingMethod == GradeNumeric:
+		return DomainMath
+	}
+
+	query := req.Query
+	switch {
+	case strings.Contains(query, "```") || codeQueryPattern.MatchString(query):
+		return DomainCode
+	case mathQueryPattern.MatchString(query):
+		return DomainMath
+	case writingQueryPattern.MatchString(query):
+		return DomainWriting
+	}
+	return DomainGeneral
+}
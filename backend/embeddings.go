@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Embedding API structures (OpenAI-compatible /v1/embeddings, served by LM Studio)
+type EmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type EmbeddingResponse struct {
+	Data []struct {
+		Embedding []float64 `json:"embedding"`
+		Index     int       `json:"index"`
+	} `json:"data"`
+}
+
+// Similarity methods reported alongside scores
+const (
+	SimilarityEmbedding = "embedding"
+	SimilarityLexical   = "lexical"
+)
+
+// Pairwise similarity between responses plus each response's agreement with the group
+type ResponseSimilarity struct {
+	Method    string
+	Matrix    [][]float64
+	Consensus []float64
+}
+
+// Fetch embeddings for a batch of texts, preserving input order
+func fetchEmbeddings(ctx context.Context, texts []string) ([][]float64, error) {
+	reqBody, err := json.Marshal(EmbeddingRequest{
+		Model: EmbeddingModel,
+		Input: texts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embedding request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", EmbeddingsAPIURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedding request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding API error (status %d): %s", resp.StatusCode, string(responseBody))
+	}
+
+	var embResp EmbeddingResponse
+	if err := json.Unmarshal(responseBody, &embResp); err != nil {
+		return nil, fmt.Errorf("failed to parse embedding response: %v", err)
+	}
+
+	if len(embResp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(embResp.Data))
+	}
+
+	embeddings := make([][]float64, len(texts))
+	for _, item := range embResp.Data {
+		if item.Index < 0 || item.Index >= len(texts) || len(item.Embedding) == 0 {
+			return nil, fmt.Errorf("invalid embedding at index %d", item.Index)
+		}
+		embeddings[item.Index] = item.Embedding
+	}
+
+	return embeddings, nil
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0.0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0.0
+	}
+
+	// Clamp to 0-1; opposing directions are treated as unrelated
+	return math.Max(0.0, math.Min(1.0, dot/(math.Sqrt(normA)*math.Sqrt(normB))))
+}
+
+// Set of significant lowercase words (longer than 3 characters)
+func significantWords(text string) map[string]struct{} {
+	words := make(map[string]struct{})
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		word = strings.Trim(word, ".,;:!?\"'()[]{}*`")
+		if len(word) > 3 {
+			words[word] = struct{}{}
+		}
+	}
+	return words
+}
+
+// Jaccard similarity over significant word sets, used when embeddings are unavailable
+func lexicalSimilarity(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0.0
+	}
+
+	overlap := 0
+	for word := range a {
+		if _, ok := b[word]; ok {
+			overlap++
+		}
+	}
+
+	return float64(overlap) / float64(len(a)+len(b)-overlap)
+}
+
+// Compute pairwise similarity for all responses, preferring embeddings and
+// falling back to lexical overlap. Error and empty responses get zero similarity.
+func computeResponseSimilarity(ctx context.Context, responses []AIResult) *ResponseSimilarity {
+	n := len(responses)
+	sim := &ResponseSimilarity{
+		Method:    SimilarityEmbedding,
+		Matrix:    make([][]float64, n),
+		Consensus: make([]float64, n),
+	}
+	for i := range sim.Matrix {
+		sim.Matrix[i] = make([]float64, n)
+	}
+
+	usable := make([]int, 0, n)
+	texts := make([]string, 0, n)
+	for i, resp := range responses {
+		if resp.Error == "" && strings.TrimSpace(resp.Output) != "" {
+			usable = append(usable, i)
+			texts = append(texts, resp.Output)
+		}
+	}
+
+	if len(usable) == 0 {
+		return sim
+	}
+
+	embeddings, err := fetchEmbeddings(ctx, texts)
+	if err != nil {
+		sim.Method = SimilarityLexical
+		fillLexicalSimilarity(sim, usable, texts)
+		return sim
+	}
+
+	// Centroid of all usable responses represents the group's "average" answer
+	dims := len(embeddings[0])
+	centroid := make([]float64, dims)
+	for _, emb := range embeddings {
+		if len(emb) != dims {
+			sim.Method = SimilarityLexical
+			fillLexicalSimilarity(sim, usable, texts)
+			return sim
+		}
+		for d, v := range emb {
+			centroid[d] += v / float64(len(embeddings))
+		}
+	}
+
+	for a, i := range usable {
+		sim.Matrix[i][i] = 1.0
+		for b := a + 1; b < len(usable); b++ {
+			j := usable[b]
+			s := cosineSimilarity(embeddings[a], embeddings[b])
+			sim.Matrix[i][j] = s
+			sim.Matrix[j][i] = s
+		}
+		sim.Consensus[i] = cosineSimilarity(embeddings[a], centroid)
+	}
+
+	return sim
+}
+
+func fillLexicalSimilarity(sim *ResponseSimilarity, usable []int, texts []string) {
+	wordSets := make([]map[string]struct{}, len(texts))
+	for a, text := range texts {
+		wordSets[a] = significantWords(text)
+	}
+
+	for a, i := range usable {
+		sim.Matrix[i][i] = 1.0
+		for b := a + 1; b < len(usable); b++ {
+			j := usable[b]
+			s := lexicalSimilarity(wordSets[a], wordSets[b])
+			sim.Matrix[i][j] = s
+			sim.Matrix[j][i] = s
+		}
+	}
+
+	// Without vectors there is no centroid, so consensus is mean agreement with the others
+	for _, i := range usable {
+		sim.Consensus[i] = sim.averageSimilarity(i)
+	}
+}
+
+// Mean similarity of response i to every other usable response
+func (s *ResponseSimilarity) averageSimilarity(i int) float64 {
+	total := 0.0
+	comparisons := 0
+	for j, v := range s.Matrix[i] {
+		if j == i || s.Matrix[j][j] == 0 {
+			continue // Skip self and unusable responses
+		}
+		total += v
+		comparisons++
+	}
+	if comparisons == 0 {
+		return 0.0
+	}
+	return total / float64(comparisons)
+}
+
+// Number of usable responses the similarity was computed over
+func (s *ResponseSimilarity) usableCount() int {
+	count := 0
+	for i := range s.Matrix {
+		if s.Matrix[i][i] > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// Restrict the similarity to a subset of responses, re-indexed in subset order
+func (s *ResponseSimilarity) subset(indices []int) *ResponseSimilarity {
+	sub := &ResponseSimilarity{
+		Method:    s.Method,
+		Matrix:    make([][]float64, len(indices)),
+		Consensus: make([]float64, len(indices)),
+	}
+	for a, i := range indices {
+		sub.Matrix[a] = make([]float64, len(indices))
+		for b, j := range indices {
+			sub.Matrix[a][b] = s.Matrix[i][j]
+		}
+		sub.Consensus[a] = s.Consensus[i]
+	}
+	return sub
+}
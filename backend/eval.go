@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Datasets may carry long reference answers and test programs on a single line
+const maxEvalLineBytes = 16 * 1024 * 1024
+
+// One dataset line: an id plus any QueryRequest fields (query, agents, referenceAnswer, ...)
+type EvalItem struct {
+	ID string `json:"id,omitempty"`
+	QueryRequest
+
+	line int
+}
+
+// Outcome of one dataset item; written as one JSONL line
+type EvalItemResult struct {
+	ID                string         `json:"id"`
+	Line              int            `json:"line"`
+	Query             string         `json:"query"`
+	BestResponseIndex int            `json:"bestResponseIndex"`
+	Verdict           string         `json:"verdict,omitempty"`
+	WinnerAgent       string         `json:"winnerAgent,omitempty"`
+	Agents            []string       `json:"agents"`
+	Graded            bool           `json:"graded"`
+	MasterPickCorrect bool           `json:"masterPickCorrect"`
+	OracleCorrect     bool           `json:"oracleCorrect"`
+	CorrectAgents     []string       `json:"correctAgents,omitempty"`
+	LatencyMs         int64          `json:"latencyMs"`
+	Usage             *TokenUsage    `json:"usage,omitempty"`
+	Error             string         `json:"error,omitempty"`
+	Response          *QueryResponse `json:"response,omitempty"`
+}
+
+type AgentEvalStats struct {
+	Appearances int     `json:"appearances"`
+	Wins        int     `json:"wins"`
+	WinRate     float64 `json:"winRate"`
+	Graded      int     `json:"graded"`
+	Correct     int     `json:"correct"`
+	Accuracy    float64 `json:"accuracy"`
+}
+
+type LatencyStats struct {
+	Mean int64 `json:"mean"`
+	P50  int64 `json:"p50"`
+	P90  int64 `json:"p90"`
+	P99  int64 `json:"p99"`
+	Max  int64 `json:"max"`
+}
+
+// Aggregate metrics over a benchmark run. OracleGap is how much accuracy the judge
+// leaves on the table compared with always picking a correct answer when one exists.
+type EvalSummary struct {
+	Items          int                        `json:"items"`
+	Failed         int                        `json:"failed"`
+	Graded         int                        `json:"graded"`
+	JudgeAccuracy  float64                    `json:"judgeAccuracy"`
+	OracleAccuracy float64                    `json:"oracleAccuracy"`
+	OracleGap      float64                    `json:"oracleGap"`
+	Verdicts       map[string]int             `json:"verdicts"`
+	Agents         map[string]*AgentEvalStats `json:"agents"`
+	LatencyMs      LatencyStats               `json:"latencyMs"`
+	Usage          TokenUsage                 `json:"usage"`
+	TokensPerItem  float64                    `json:"tokensPerItem"`
+	Duration       string                     `json:"duration"`
+}
+
+// hivemind eval -input dataset.jsonl [-output results.jsonl] [-summary summary.json]
+func runEvalCommand(args []string) int {
+	flags := flag.NewFlagSet("eval", flag.ContinueOnError)
+	input := flags.String("input", "", "JSONL dataset of queries (required)")
+	output := flags.String("output", "", "write per-item results as JSONL to this file (default: stdout)")
+	summaryPath := flags.String("summary", "", "write aggregate metrics as JSON to this file (default: stderr)")
+	concurrency := flags.Int("concurrency", 2, "queries processed at the same time")
+	timeout := flags.Duration("timeout", QueryTimeout, "time budget per query")
+	full := flags.Bool("full", false, "include the full QueryResponse in each result line")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *input == "" {
+		fmt.Fprintln(os.Stderr, "eval: -input is required")
+		flags.Usage()
+		return 2
+	}
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+
+	items, err := readEvalItems(*input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "eval:", err)
+		return 1
+	}
+
+	start := time.Now()
+	results := runEvalItems(items, *concurrency, *timeout, *full)
+	summary := summarizeEval(results, time.Since(start))
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "eval:", err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+	encoder := json.NewEncoder(out)
+	for _, result := range results {
+		if err := encoder.Encode(result); err != nil {
+			fmt.Fprintln(os.Stderr, "eval: writing results:", err)
+			return 1
+		}
+	}
+
+	summaryJSON, _ := json.MarshalIndent(summary, "", "  ")
+	if *summaryPath != "" {
+		if err := os.WriteFile(*summaryPath, append(summaryJSON, '\n'), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, "eval:", err)
+			return 1
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, string(summaryJSON))
+	}
+	return 0
+}
+
+func readEvalItems(path string) ([]EvalItem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	items := make([]EvalItem, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxEvalLineBytes)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var item EvalItem
+		if err := json.Unmarshal([]byte(text), &item); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if strings.TrimSpace(item.Query) == "" {
+			return nil, fmt.Errorf("%s:%d: query cannot be empty", path, line)
+		}
+		if label, err := validateQueryRequest(item.QueryRequest); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", path, line, label, err)
+		}
+		item.line = line
+		if item.ID == "" {
+			item.ID = fmt.Sprintf("line-%d", line)
+		}
+		if len(item.Agents) == 0 {
+			item.Agents = defaultEvalAgents(classifyDomain(item.QueryRequest))
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Team used for items that do not bring their own: NumWorkers generalists with generated parameters
+func defaultEvalAgents(domain string) []Agent {
+	agents := make([]Agent, NumWorkers)
+	for i := range agents {
+		name := fmt.Sprintf("Worker-%d", i+1)
+		agents[i] = Agent{Name: name, WorkerParams: tunedWorkerParams(domain, name)}
+	}
+	return agents
+}
+
+// Process items with bounded concurrency, keeping results in dataset order
+func runEvalItems(items []EvalItem, concurrency int, timeout time.Duration, full bool) []EvalItemResult {
+	results := make([]EvalItemResult, len(items))
+	slots := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for i, item := range items {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(index int, item EvalItem) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			results[index] = runEvalItem(item, timeout, full)
+
+			mu.Lock()
+			done++
+			fmt.Fprintf(os.Stderr, "eval: %d/%d %s (%dms)\n", done, len(items), item.ID, results[index].LatencyMs)
+			mu.Unlock()
+		}(i, item)
+	}
+	wg.Wait()
+	return results
+}
+
+func runEvalItem(item EvalItem, timeout time.Duration, full bool) EvalItemResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	response := processQuery(ctx, item.QueryRequest)
+	response.QueryId = generateQueryId()
+
+	result := EvalItemResult{
+		ID:                item.ID,
+		Line:              item.line,
+		Query:             item.Query,
+		BestResponseIndex: -1,
+		Agents:            make([]string, len(item.Agents)),
+		LatencyMs:         time.Since(start).Milliseconds(),
+		Usage:             response.Usage,
+	}
+	for i, agent := range item.Agents {
+		result.Agents[i] = agent.Name
+	}
+	if full {
+		result.Response = response
+	}
+
+	evaluation := response.MasterEvaluation
+	if evaluation == nil {
+		result.Error = "no evaluation produced"
+		return result
+	}
+	result.BestResponseIndex = evaluation.BestResponseIndex
+	result.Verdict = evaluation.Verdict
+	if best := evaluation.BestResponseIndex; best >= 0 && best < len(item.Agents) {
+		result.WinnerAgent = item.Agents[best].Name
+	}
+	if ctx.Err() != nil {
+		result.Error = "timed out"
+	}
+
+	if response.Grading != nil {
+		result.Graded = true
+		result.OracleCorrect = response.Grading.AnyCorrect
+		result.MasterPickCorrect = response.Grading.MasterPickCorrect != nil && *response.Grading.MasterPickCorrect
+		for i, resp := range response.Results {
+			if resp.Grade != nil && resp.Grade.Correct {
+				result.CorrectAgents = append(result.CorrectAgents, item.Agents[i].Name)
+			}
+		}
+	}
+	return result
+}
+
+func summarizeEval(results []EvalItemResult, duration time.Duration) *EvalSummary {
+	summary := &EvalSummary{
+		Items:    len(results),
+		Verdicts: make(map[string]int),
+		Agents:   make(map[string]*AgentEvalStats),
+		Duration: duration.Round(time.Millisecond).String(),
+	}
+
+	judgeCorrect, oracleCorrect := 0, 0
+	latencies := make([]int64, 0, len(results))
+	for _, result := range results {
+		latencies = append(latencies, result.LatencyMs)
+		summary.Usage.add(result.Usage)
+		if result.Error != "" {
+			summary.Failed++
+		}
+		if result.Verdict != "" {
+			summary.Verdicts[result.Verdict]++
+		}
+
+		for _, name := range result.Agents {
+			stats := summary.Agents[name]
+			if stats == nil {
+				stats = &AgentEvalStats{}
+				summary.Agents[name] = stats
+			}
+			stats.Appearances++
+			if name == result.WinnerAgent {
+				stats.Wins++
+			}
+			if result.Graded {
+				stats.Graded++
+				if containsString(result.CorrectAgents, name) {
+					stats.Correct++
+				}
+			}
+		}
+
+		if result.Graded {
+			summary.Graded++
+			if result.MasterPickCorrect {
+				judgeCorrect++
+			}
+			if result.OracleCorrect {
+				oracleCorrect++
+			}
+		}
+	}
+
+	if summary.Graded > 0 {
+		summary.JudgeAccuracy = float64(judgeCorrect) / float64(summary.Graded)
+		summary.OracleAccuracy = float64(oracleCorrect) / float64(summary.Graded)
+		summary.OracleGap = summary.OracleAccuracy - summary.JudgeAccuracy
+	}
+	for _, stats := range summary.Agents {
+		if stats.Appearances > 0 {
+			stats.WinRate = float64(stats.Wins) / float64(stats.Appearances)
+		}
+		if stats.Graded > 0 {
+			stats.Accuracy = float64(stats.Correct) / float64(stats.Graded)
+		}
+	}
+	if len(results) > 0 {
+		summary.TokensPerItem = float64(summary.Usage.TotalTokens) / float64(len(results))
+	}
+	summary.LatencyMs = latencyPercentiles(latencies)
+
+	return summary
+}
+
+// Nearest-rank percentiles
+func latencyPercentiles(latencies []int64) LatencyStats {
+	if len(latencies) == 0 {
+		return LatencyStats{}
+	}
+	sorted := append([]int64{}, latencies...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a] < sorted[b] })
+
+	var total int64
+	for _, latency := range sorted {
+		total += latency
+	}
+	percentile := func(p float64) int64 {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		if rank < 1 {
+			rank = 1
+		}
+		return sorted[rank-1]
+	}
+
+	return LatencyStats{
+		Mean: total / int64(len(sorted)),
+		P50:  percentile(50),
+		P90:  percentile(90),
+		P99:  percentile(99),
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sandbox limits for running generated code
+const (
+	ExecutionTimeout        = 10 * time.Second
+	CompileTimeout          = 60 * time.Second
+	SandboxWaitDelay        = time.Second
+	MaxConcurrentExecutions = 2
+	maxCapturedOutput       = 4096
+)
+
+// Resource limits applied inside the sandbox
+type sandboxLimits struct {
+	Timeout    time.Duration `json:"-"`
+	CPUSeconds int           `json:"cpuSeconds"`
+	MemoryKB   int           `json:"memoryKB"`
+	FileKB     int           `json:"fileKB"`
+	Processes  int           `json:"processes"`
+}
+
+// Candidate programs get tight limits; the Go toolchain needs room for the
+// compiler's threads and build cache entries
+var (
+	ExecutionLimits = sandboxLimits{
+		Timeout:    ExecutionTimeout,
+		CPUSeconds: 10,
+		MemoryKB:   512 * 1024,
+		FileKB:     10 * 1024,
+		Processes:  64,
+	}
+	CompileLimits = sandboxLimits{
+		Timeout:    CompileTimeout,
+		CPUSeconds: 120,
+		MemoryKB:   2 * 1024 * 1024,
+		FileKB:     256 * 1024,
+		Processes:  512,
+	}
+)
+
+// Argument that makes the binary act as the init process of a sandbox
+const sandboxInitCommand = "__sandbox-init"
+
+// Where the sandbox sees its working directory and the shared Go build cache
+const (
+	sandboxWorkDir  = "/work"
+	sandboxCacheDir = "/gocache"
+)
+
+// What the sandbox's init process sets up before executing Command. Host paths
+// are WorkDir, CacheDir, ReadOnly and the empty mount point Root; Dir is the
+// working directory inside the sandbox.
+type sandboxSpec struct {
+	Root     string        `json:"root"`
+	WorkDir  string        `json:"workDir"`
+	CacheDir string        `json:"cacheDir,omitempty"`
+	ReadOnly []string      `json:"readOnly,omitempty"`
+	Dir      string        `json:"dir"`
+	Env      []string      `json:"env"`
+	Command  []string      `json:"command"`
+	Limits   sandboxLimits `json:"limits"`
+}
+
+var (
+	sandboxProbe    sync.Once
+	sandboxProbeErr error
+)
+
+// Whether sandboxed execution works on this host, checked once
+func sandboxAvailable() error {
+	sandboxProbe.Do(func() {
+		sandboxProbeErr = probeSandbox()
+		if sandboxProbeErr != nil {
+			log.Printf("Sandboxed execution is unavailable, test cases will not be run: %v", sandboxProbeErr)
+		}
+	})
+	return sandboxProbeErr
+}
+
+// Languages that can be executed
+const (
+	ExecGo     = "go"
+	ExecPython = "python"
+)
+
+// A test case supplied with a query. Either Stdin/ExpectedStdout or GoTest is set.
+type TestCase struct {
+	Name           string `json:"name,omitempty"`
+	Stdin          string `json:"stdin,omitempty"`
+	ExpectedStdout string `json:"expectedStdout,omitempty"`
+	GoTest         string `json:"goTest,omitempty"`
+}
+
+// Outcome of running one agent's code against every test case
+type ExecutionReport struct {
+	Language string           `json:"language,omitempty"`
+	Passed   int              `json:"passed"`
+	Total    int              `json:"total"`
+	PassRate float64          `json:"passRate"`
+	Error    string           `json:"error,omitempty"`
+	Cases    []TestCaseResult `json:"cases,omitempty"`
+}
+
+type TestCaseResult struct {
+	Name           string `json:"name"`
+	Passed         bool   `json:"passed"`
+	Stdout         string `json:"stdout,omitempty"`
+	Stderr         string `json:"stderr,omitempty"`
+	Error          string `json:"error,omitempty"`
+	ProcessingTime int64  `json:"processingTime"`
+}
+
+// Maximum test cases accepted per query
+const MaxTestCases = 20
+
+func validateTestCases(testCases []TestCase) error {
+	if len(testCases) > MaxTestCases {
+		return fmt.Errorf("at most %d test cases are allowed, got %d", MaxTestCases, len(testCases))
+	}
+	for i, tc := range testCases {
+		if tc.GoTest != "" && (tc.Stdin != "" || tc.ExpectedStdout != "") {
+			return fmt.Errorf("test case %d mixes goTest with stdin/expectedStdout", i+1)
+		}
+	}
+	return nil
+}
+
+// Pick the code block to execute: the first Go or Python block, preferring a
+// Go block with func main when the tests feed stdin
+func extractExecutableCode(output string, needsMain bool) (string, string) {
+	language, code := "", ""
+	for _, match := range fencedBlockPattern.FindAllStringSubmatch(output, -1) {
+		var blockLanguage string
+		switch strings.ToLower(match[1]) {
+		case "go", "golang":
+			blockLanguage = ExecGo
+		case "python", "py", "python3":
+			blockLanguage = ExecPython
+		default:
+			continue
+		}
+
+		if blockLanguage == ExecGo && needsMain && strings.Contains(match[2], "func main(") {
+			return blockLanguage, match[2]
+		}
+		if language == "" {
+			language, code = blockLanguage, match[2]
+		}
+	}
+	return language, code
+}
+
+// Run every successful result's code against the test cases, a few agents at a time
+func executeTestCases(ctx context.Context, results []AIResult, testCases []TestCase) {
+	if len(testCases) == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, MaxConcurrentExecutions)
+
+	for i := range results {
+		if results[i].Error != "" || strings.TrimSpace(results[i].Output) == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			results[index].Execution = runTestCases(ctx, results[index].Output, testCases)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+func runTestCases(ctx context.Context, output string, testCases []TestCase) *ExecutionReport {
+	needsMain := false
+	for _, tc := range testCases {
+		if tc.GoTest == "" {
+			needsMain = true
+		}
+	}
+
+	language, code := extractExecutableCode(output, needsMain)
+
+	// Without a sandbox nothing was run, which is not the same as failing every case
+	if err := sandboxAvailable(); err != nil {
+		return &ExecutionReport{Language: language, Error: fmt.Sprintf("Sandboxed execution is unavailable: %v", err)}
+	}
+
+	report := &ExecutionReport{Total: len(testCases), Cases: make([]TestCaseResult, 0, len(testCases))}
+	if language == "" {
+		report.Error = "No Go or Python code block found"
+		return report
+	}
+	report.Language = language
+
+	workDir, err := os.MkdirTemp("", "hivemind-exec-")
+	if err != nil {
+		report.Error = fmt.Sprintf("Failed to create sandbox directory: %v", err)
+		return report
+	}
+	defer os.RemoveAll(workDir)
+
+	// Programs fed through stdin are built once and reused for every such case
+	var program []string
+	if needsMain {
+		program, err = prepareProgram(ctx, workDir, language, code)
+		if err != nil {
+			report.Error = err.Error()
+		}
+	}
+
+	for i, tc := range testCases {
+		name := tc.Name
+		if name == "" {
+			name = fmt.Sprintf("case %d", i+1)
+		}
+
+		var result TestCaseResult
+		if tc.GoTest != "" {
+			result = runGoTestCase(ctx, workDir, i, language, code, tc)
+		} else if program == nil {
+			result = TestCaseResult{Error: "Program could not be built"}
+		} else {
+			result = runStdinCase(ctx, workDir, program, tc)
+		}
+		result.Name = name
+
+		if result.Passed {
+			report.Passed++
+		}
+		report.Cases = append(report.Cases, result)
+	}
+
+	report.PassRate = float64(report.Passed) / float64(report.Total)
+	return report
+}
+
+// Write the candidate to disk and compile it if needed, returning the command to
+// run from the sandbox's working directory
+func prepareProgram(ctx context.Context, workDir, language, code string) ([]string, error) {
+	switch language {
+	case ExecPython:
+		if err := os.WriteFile(filepath.Join(workDir, "solution.py"), []byte(code), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write solution: %v", err)
+		}
+		return []string{"python3", "-I", "solution.py"}, nil
+	default:
+		if err := writeGoModule(filepath.Join(workDir, "program"), withPackageClause(code, "main"), ""); err != nil {
+			return nil, err
+		}
+		if out, err := compileGo(ctx, workDir, "program", "build", "-o", "program", "."); err != nil {
+			return nil, fmt.Errorf("compilation failed (%v): %s", err, truncateOutput(out))
+		}
+		return []string{"./program/program"}, nil
+	}
+}
+
+func runStdinCase(ctx context.Context, workDir string, program []string, tc TestCase) TestCaseResult {
+	start := time.Now()
+	stdout, stderr, err := runSandboxed(ctx, workDir, ".", program, tc.Stdin, ExecutionLimits)
+
+	result := TestCaseResult{
+		Stdout:         truncateOutput(stdout),
+		Stderr:         truncateOutput(stderr),
+		ProcessingTime: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Passed = normalizeProgramOutput(stdout) == normalizeProgramOutput(tc.ExpectedStdout)
+	return result
+}
+
+// Compile the candidate together with a Go test snippet and run the test binary in the sandbox
+func runGoTestCase(ctx context.Context, workDir string, index int, language, code string, tc TestCase) TestCaseResult {
+	start := time.Now()
+	if language != ExecGo {
+		return TestCaseResult{Error: "Go test snippets require a Go solution"}
+	}
+
+	packageName := goPackageName(code)
+	if packageName == "" {
+		packageName = "solution"
+	}
+
+	testSource := tc.GoTest
+	if !hasPackageClause(testSource) {
+		if !strings.Contains(testSource, "import") {
+			testSource = "import \"testing\"\n\n" + testSource
+		}
+		testSource = fmt.Sprintf("package %s\n\n%s", packageName, testSource)
+	}
+
+	dir := fmt.Sprintf("test%d", index)
+	if err := writeGoModule(filepath.Join(workDir, dir), withPackageClause(code, packageName), testSource); err != nil {
+		return TestCaseResult{Error: err.Error()}
+	}
+
+	if out, err := compileGo(ctx, workDir, dir, "test", "-c", "-o", "solution.test", "."); err != nil {
+		return TestCaseResult{
+			Error:          fmt.Sprintf("Compilation failed: %v", err),
+			Stderr:         truncateOutput(out),
+			ProcessingTime: time.Since(start).Milliseconds(),
+		}
+	}
+
+	command := []string{"./solution.test", "-test.v", fmt.Sprintf("-test.timeout=%s", ExecutionTimeout)}
+	stdout, stderr, err := runSandboxed(ctx, workDir, dir, command, "", ExecutionLimits)
+	result := TestCaseResult{
+		Stdout:         truncateOutput(stdout),
+		Stderr:         truncateOutput(stderr),
+		ProcessingTime: time.Since(start).Milliseconds(),
+		Passed:         err == nil,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func writeGoModule(dir, source, testSource string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create module directory: %v", err)
+	}
+	files := map[string]string{
+		"go.mod":      "module candidate\n\ngo 1.21\n",
+		"solution.go": source,
+	}
+	if testSource != "" {
+		files["solution_test.go"] = testSource
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Compile inside the sandbox with the local toolchain only: no module downloads,
+// no toolchain switching and nothing from the server's environment. The build
+// cache is shared between compilations so the standard library is built once.
+func compileGo(ctx context.Context, workDir, dir string, args ...string) (string, error) {
+	goroot, err := goToolchainRoot()
+	if err != nil {
+		return "", err
+	}
+
+	command := append([]string{filepath.Join(goroot, "bin", "go")}, args...)
+	env := []string{
+		"GOROOT=" + goroot,
+		"GOCACHE=" + sandboxCacheDir,
+		"GOPATH=/tmp/gopath",
+		"GOPROXY=off",
+		"GOFLAGS=-mod=mod",
+		"GOTOOLCHAIN=local",
+		"GOWORK=off",
+		"GOENV=off",
+		"GOTELEMETRY=off",
+		"CGO_ENABLED=0",
+	}
+	stdout, stderr, err := runSandbox(ctx, sandboxSpec{
+		WorkDir:  workDir,
+		CacheDir: goBuildCacheDir(),
+		ReadOnly: []string{goroot},
+		Dir:      path.Join(sandboxWorkDir, dir),
+		Env:      append(sandboxEnv(), env...),
+		Command:  command,
+		Limits:   CompileLimits,
+	}, "")
+	return stdout + stderr, err
+}
+
+var (
+	goRootOnce sync.Once
+	goRootDir  string
+	goRootErr  error
+)
+
+// Locate the Go installation on the host so it can be mounted into the sandbox
+func goToolchainRoot() (string, error) {
+	goRootOnce.Do(func() {
+		binary, err := exec.LookPath("go")
+		if err == nil {
+			binary, err = filepath.EvalSymlinks(binary)
+		}
+		if err != nil {
+			goRootErr = fmt.Errorf("Go toolchain not found: %v", err)
+			return
+		}
+		goRootDir = filepath.Dir(filepath.Dir(binary))
+	})
+	return goRootDir, goRootErr
+}
+
+func goBuildCacheDir() string {
+	dir := filepath.Join(os.TempDir(), "hivemind-gocache")
+	os.MkdirAll(dir, 0700)
+	return dir
+}
+
+// The environment every sandboxed command starts from
+func sandboxEnv() []string {
+	return []string{"PATH=/usr/local/bin:/usr/bin:/bin", "HOME=" + sandboxWorkDir, "TMPDIR=/tmp"}
+}
+
+// Run a command from dir (relative to workDir) with CPU, memory, file-size and
+// process limits, a wall-clock timeout, an empty environment, no network and
+// no view of the host filesystem beyond workDir and the system directories
+func runSandboxed(ctx context.Context, workDir, dir string, command []string, stdin string, limits sandboxLimits) (string, string, error) {
+	return runSandbox(ctx, sandboxSpec{
+		WorkDir: workDir,
+		Dir:     path.Join(sandboxWorkDir, dir),
+		Env:     sandboxEnv(),
+		Command: command,
+		Limits:  limits,
+	}, stdin)
+}
+
+func runSandbox(ctx context.Context, spec sandboxSpec, stdin string) (string, string, error) {
+	runCtx, cancel := context.WithTimeout(ctx, spec.Limits.Timeout)
+	defer cancel()
+
+	// The init process mounts the sandbox's root over this empty directory
+	root, err := os.MkdirTemp("", "hivemind-root-")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create sandbox root: %v", err)
+	}
+	defer os.Remove(root)
+	spec.Root = root
+
+	cmd, err := newSandboxCommand(runCtx, spec)
+	if err != nil {
+		return "", "", err
+	}
+	cmd.Stdin = strings.NewReader(stdin)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if runCtx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", spec.Limits.Timeout)
+	}
+	return stdout.String(), stderr.String(), err
+}
+
+func goPackageName(code string) string {
+	for _, line := range strings.Split(code, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "package ") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "package "))
+		}
+	}
+	return ""
+}
+
+func withPackageClause(code, packageName string) string {
+	if hasPackageClause(code) {
+		return code
+	}
+	return fmt.Sprintf("package %s\n\n%s", packageName, code)
+}
+
+// Compare outputs ignoring trailing whitespace on each line and trailing blank lines
+func normalizeProgramOutput(output string) string {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+func truncateOutput(output string) string {
+	if len(output) <= maxCapturedOutput {
+		return output
+	}
+	return output[:maxCapturedOutput] + "\n... (truncated)"
+}
+
+// Pass rate as a scorer; responses without test results are not penalized
+func calculateTestScore(report *ExecutionReport) float64 {
+	if report == nil || report.Total == 0 {
+		return 1.0
+	}
+	return report.PassRate
+}
+
+// Order the judge's candidates so the highest pass rates come first
+func sortCandidatesByPassRate(validResponses []AIResult, validIndices []int) {
+	for i := 1; i < len(validResponses); i++ {
+		for j := i; j > 0 && executionPassRate(validResponses[j]) > executionPassRate(validResponses[j-1]); j-- {
+			validResponses[j], validResponses[j-1] = validResponses[j-1], validResponses[j]
+			validIndices[j], validIndices[j-1] = validIndices[j-1], validIndices[j]
+		}
+	}
+}
+
+func executionPassRate(response AIResult) float64 {
+	if response.Execution == nil || response.Execution.Total == 0 {
+		return -1.0
+	}
+	return response.Execution.PassRate
+}
+
+// Make test pass rate the primary ranking key, keeping the evaluator's order within
+// equal pass rates. The best response becomes the top of the re-sorted ranking.
+func applyExecutionResults(evaluation *MasterEvaluation, responses []AIResult) {
+	if evaluation == nil || len(evaluation.Rankings) == 0 {
+		return
+	}
+
+	hasExecution := false
+	passRate := func(index int) float64 {
+		if index < 0 || index >= len(responses) {
+			return -1.0
+		}
+		return executionPassRate(responses[index])
+	}
+	for _, ranking := range evaluation.Rankings {
+		if passRate(ranking.Index) >= 0 {
+			hasExecution = true
+		}
+	}
+	if !hasExecution {
+		return
+	}
+
+	rankings := evaluation.Rankings
+	for i := 1; i < len(rankings); i++ {
+		for j := i; j > 0 && passRate(rankings[j].Index) > passRate(rankings[j-1].Index); j-- {
+			rankings[j], rankings[j-1] = rankings[j-1], rankings[j]
+		}
+	}
+	for i := range rankings {
+		if report := responses[rankings[i].Index].Execution; report != nil && report.Total > 0 {
+			rankings[i].Reasoning += fmt.Sprintf(" (tests: %d/%d passed)", report.Passed, report.Total)
+		}
+	}
+
+	// Only change an actual pick; "none acceptable" and abstentions stand
+	top := rankings[0].Index
+	if evaluation.BestResponseIndex >= 0 && top != evaluation.BestResponseIndex && passRate(top) > passRate(evaluation.BestResponseIndex) {
+		evaluation.Reasoning = fmt.Sprintf("%s [Overridden: %s passed more test cases]", evaluation.Reasoning, responses[top].Model)
+		evaluation.BestResponseIndex = top
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	ExportPairs = "pairs"
+	ExportSFT   = "sft"
+)
+
+// Who decided which answer was preferred
+const (
+	PreferenceMaster = "master"
+	PreferenceHuman  = "human"
+)
+
+// A DPO-style training example: the same prompt with a preferred and a worse answer
+type PreferencePair struct {
+	Prompt        string  `json:"prompt"`
+	Chosen        string  `json:"chosen"`
+	Rejected      string  `json:"rejected"`
+	QueryId       string  `json:"queryId"`
+	ChosenAgent   string  `json:"chosenAgent"`
+	RejectedAgent string  `json:"rejectedAgent"`
+	Margin        float64 `json:"margin"`
+	Source        string  `json:"source"`
+}
+
+// A supervised fine-tuning example: the prompt and its preferred answer
+type SFTRecord struct {
+	Prompt     string `json:"prompt"`
+	Completion string `json:"completion"`
+	QueryId    string `json:"queryId"`
+	Agent      string `json:"agent"`
+	Source     string `json:"source"`
+}
+
+// MinMargin is the master score gap a master-judged pair must exceed; human picks are
+// kept regardless of how the master scored them
+type ExportOptions struct {
+	Format         string
+	MinMargin      float64
+	HumanConfirmed bool
+	Dedup          bool
+	Redactors      []Redactor
+	From           *time.Time
+	To             *time.Time
+}
+
+type ExportStats struct {
+	Queries    int `json:"queries"`
+	Exported   int `json:"exported"`
+	Duplicates int `json:"duplicates"`
+}
+
+// Scrubs sensitive text from prompts and answers before they leave the system
+type Redactor interface {
+	Name() string
+	Redact(text string) string
+}
+
+type patternRedactor struct {
+	name        string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+func (r patternRedactor) Name() string { return r.name }
+
+func (r patternRedactor) Redact(text string) string {
+	return r.pattern.ReplaceAllString(text, r.replacement)
+}
+
+var redactors = make(map[string]Redactor)
+
+func registerRedactor(redactor Redactor) {
+	redactors[redactor.Name()] = redactor
+}
+
+func init() {
+	registerRedactor(patternRedactor{"email", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[EMAIL]"})
+	registerRedactor(patternRedactor{"phone", phonePattern, "${1}[PHONE]${2}"})
+	registerRedactor(ipRedactor{})
+	registerRedactor(patternRedactor{"secret", regexp.MustCompile(`\b(?:sk-[A-Za-z0-9_-]{16,}|gh[pousr]_[A-Za-z0-9]{20,}|AKIA[0-9A-Z]{16}|[Bb]earer\s+[A-Za-z0-9._~+/=-]{16,})`), "[SECRET]"})
+}
+
+// Phone numbers need separated digit groups (555-123-4567, (555) 123 4567, +44 20 7946 0958),
+// so plain numbers such as 3.14159265 or 1,000,000.00 are left alone
+var phonePattern = regexp.MustCompile(`(^|[^\w.,+])(?:\+\d{1,3}[ .-]?)?(?:\(\d{2,4}\)[ .-]?|\d{2,4}[ .-])\d{3,4}[ .-]\d{3,4}($|[^\w.,]|[.,](?:\s|$))`)
+
+var (
+	dottedQuadPattern = regexp.MustCompile(`\d{1,3}(?:\.\d{1,3}){3}`)
+	versionLabel      = regexp.MustCompile(`(?i)(\bv|\bversion|\bver\.?|\brelease|\bbuild)\s*$`)
+)
+
+// Redacts IPv4 addresses: four valid octets that are not part of a longer dotted
+// number and not labelled as a version ("v1.2.3.4", "version 10.0.0.1")
+type ipRedactor struct{}
+
+func (ipRedactor) Name() string { return "ip" }
+
+func (ipRedactor) Redact(text string) string {
+	var out strings.Builder
+	last := 0
+	for _, match := range dottedQuadPattern.FindAllStringIndex(text, -1) {
+		start, end := match[0], match[1]
+		if !isIPv4Address(text, start, end) {
+			continue
+		}
+		out.WriteString(text[last:start])
+		out.WriteString("[IP]")
+		last = end
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
+
+func isIPv4Address(text string, start, end int) bool {
+	if start > 0 && isDottedNumberChar(text[start-1]) {
+		return false
+	}
+	if end < len(text) && (isWordChar(text[end]) || (text[end] == '.' && end+1 < len(text) && isWordChar(text[end+1]))) {
+		return false
+	}
+	for _, octet := range strings.Split(text[start:end], ".") {
+		if len(octet) > 1 && octet[0] == '0' {
+			return false
+		}
+		if value, _ := strconv.Atoi(octet); value > 255 {
+			return false
+		}
+	}
+	return !versionLabel.MatchString(text[:start])
+}
+
+func isDottedNumberChar(c byte) bool {
+	return c == '.' || isWordChar(c)
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// Resolve a comma-separated list of redactor names; "all" selects every registered one
+func parseRedactors(spec string) ([]Redactor, error) {
+	selected := make([]Redactor, 0)
+	if strings.TrimSpace(spec) == "" {
+		return selected, nil
+	}
+	if strings.TrimSpace(spec) == "all" {
+		for _, name := range registeredRedactorNames() {
+			selected = append(selected, redactors[name])
+		}
+		return selected, nil
+	}
+	for _, name := range strings.Split(spec, ",") {
+		redactor, ok := redactors[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown redactor %q (available: %s)", strings.TrimSpace(name), strings.Join(registeredRedactorNames(), ", "))
+		}
+		selected = append(selected, redactor)
+	}
+	return selected, nil
+}
+
+func registeredRedactorNames() []string {
+	names := make([]string, 0, len(redactors))
+	for name := range redactors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateExportOptions(options ExportOptions) error {
+	if options.Format != ExportPairs && options.Format != ExportSFT {
+		return fmt.Errorf("unknown format %q (use %s or %s)", options.Format, ExportPairs, ExportSFT)
+	}
+	if options.MinMargin < 0 {
+		return fmt.Errorf("minimum margin cannot be negative")
+	}
+	if options.From != nil && options.To != nil && !options.From.Before(*options.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	return nil
+}
+
+// Read GET /export parameters: format, minMargin, humanOnly, dedup, redact, from, to
+func parseExportOptions(values url.Values) (ExportOptions, error) {
+	options := ExportOptions{Format: values.Get("format"), Dedup: true}
+	if options.Format == "" {
+		options.Format = ExportPairs
+	}
+
+	var err error
+	if value := values.Get("minMargin"); value != "" {
+		if options.MinMargin, err = strconv.ParseFloat(value, 64); err != nil {
+			return options, fmt.Errorf("minMargin must be a number")
+		}
+	}
+	if value := values.Get("humanOnly"); value != "" {
+		if options.HumanConfirmed, err = strconv.ParseBool(value); err != nil {
+			return options, fmt.Errorf("humanOnly must be true or false")
+		}
+	}
+	if value := values.Get("dedup"); value != "" {
+		if options.Dedup, err = strconv.ParseBool(value); err != nil {
+			return options, fmt.Errorf("dedup must be true or false")
+		}
+	}
+	if options.Redactors, err = parseRedactors(values.Get("redact")); err != nil {
+		return options, err
+	}
+	if value := values.Get("from"); value != "" {
+		from, err := parseFilterTime(value, false)
+		if err != nil {
+			return options, fmt.Errorf("from: %v", err)
+		}
+		options.From = &from
+	}
+	if value := values.Get("to"); value != "" {
+		to, err := parseFilterTime(value, true)
+		if err != nil {
+			return options, fmt.Errorf("to: %v", err)
+		}
+		options.To = &to
+	}
+	return options, validateExportOptions(options)
+}
+
+// Walk stored queries and emit training records. The latest human pick for a query
+// overrides the master's; queries where nobody found an acceptable answer are skipped.
+func exportTrainingData(ctx context.Context, store Store, options ExportOptions, emit func(record interface{}) error) (*ExportStats, error) {
+	feedback, err := store.ListFeedback(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	picks := make(map[string]QueryFeedback)
+	for _, entry := range feedback {
+		if latest, ok := picks[entry.QueryId]; !ok || !entry.Timestamp.Before(latest.Timestamp) {
+			picks[entry.QueryId] = entry
+		}
+	}
+
+	stats := &ExportStats{}
+	seen := make(map[[sha256.Size]byte]bool)
+	err = store.ScanQueries(ctx, func(record *QueryRecord) error {
+		if options.From != nil && record.CreatedAt.Before(*options.From) {
+			return nil
+		}
+		if options.To != nil && !record.CreatedAt.Before(*options.To) {
+			return nil
+		}
+		var pick *QueryFeedback
+		if entry, ok := picks[record.QueryId]; ok {
+			pick = &entry
+		} else if options.HumanConfirmed || servedFromCache(record) {
+			// A cached response without its own pick repeats an earlier query's examples
+			return nil
+		}
+		stats.Queries++
+
+		for _, example := range trainingExamples(record, pick, options) {
+			if options.Dedup {
+				key := dedupKey(example)
+				if seen[key] {
+					stats.Duplicates++
+					continue
+				}
+				seen[key] = true
+			}
+			if err := emit(example); err != nil {
+				return err
+			}
+			stats.Exported++
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// Examples from one query, already redacted
+func trainingExamples(record *QueryRecord, pick *QueryFeedback, options ExportOptions) []interface{} {
+	response := record.Response
+	if response == nil || len(response.Results) == 0 {
+		return nil
+	}
+
+	source, chosen := PreferenceMaster, -1
+	if pick != nil {
+		source, chosen = PreferenceHuman, pick.BestIndex
+	} else if evaluation := response.MasterEvaluation; evaluation != nil && evaluation.Verdict != VerdictNoneAcceptable && evaluation.Verdict != VerdictAbstain {
+		chosen = evaluation.BestResponseIndex
+	}
+	if chosen < 0 || chosen >= len(response.Results) || !usableForTraining(response.Results[chosen]) {
+		return nil
+	}
+
+	agents := agentIdentities(record)
+	redact := func(text string) string {
+		for _, redactor := range options.Redactors {
+			text = redactor.Redact(text)
+		}
+		return text
+	}
+	prompt := redact(record.Request.Query)
+
+	if options.Format == ExportSFT {
+		return []interface{}{SFTRecord{
+			Prompt:     prompt,
+			Completion: redact(response.Results[chosen].Output),
+			QueryId:    record.QueryId,
+			Agent:      agents[chosen].Name,
+			Source:     source,
+		}}
+	}
+
+	// Answers the master called tied with its pick are not worse, so never "rejected" by it
+	scores := make(map[int]float64)
+	tied := make(map[int]bool)
+	if response.MasterEvaluation != nil {
+		for _, ranking := range response.MasterEvaluation.Rankings {
+			scores[ranking.Index] = ranking.Score
+		}
+		for _, index := range response.MasterEvaluation.TiedIndices {
+			tied[index] = true
+		}
+	}
+
+	examples := make([]interface{}, 0, len(response.Results)-1)
+	for i, result := range response.Results {
+		if i == chosen || !usableForTraining(result) {
+			continue
+		}
+		margin := scores[chosen] - scores[i]
+		if source == PreferenceMaster && (tied[i] || margin <= options.MinMargin) {
+			continue
+		}
+		examples = append(examples, PreferencePair{
+			Prompt:        prompt,
+			Chosen:        redact(response.Results[chosen].Output),
+			Rejected:      redact(result.Output),
+			QueryId:       record.QueryId,
+			ChosenAgent:   agents[chosen].Name,
+			RejectedAgent: agents[i].Name,
+			Margin:        margin,
+			Source:        source,
+		})
+	}
+	return examples
+}
+
+func usableForTraining(result AIResult) bool {
+	return result.Error == "" && strings.TrimSpace(result.Output) != ""
+}
+
+// Identical text after case and whitespace folding counts as a duplicate
+func dedupKey(example interface{}) [sha256.Size]byte {
+	normalize := func(text string) string {
+		return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+	}
+	var parts []string
+	switch example := example.(type) {
+	case PreferencePair:
+		parts = []string{example.Prompt, example.Chosen, example.Rejected}
+	case SFTRecord:
+		parts = []string{example.Prompt, example.Completion}
+	}
+	for i := range parts {
+		parts[i] = normalize(parts[i])
+	}
+	return sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+}
+
+// "hivemind export" writes training data from the configured store as JSONL
+func runExportCommand(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", ExportPairs, "pairs (prompt/chosen/rejected) or sft (prompt/completion)")
+	output := flags.String("output", "", "write records to this file (default: stdout)")
+	minMargin := flags.Float64("min-margin", 0, "master score gap by which a chosen answer must beat a rejected one")
+	humanOnly := flags.Bool("human-only", false, "only export queries a user has picked an answer for")
+	dedup := flags.Bool("dedup", true, "drop records identical to one already written")
+	redact := flags.String("redact", "", "comma-separated redactors to apply, or \"all\" ("+strings.Join(registeredRedactorNames(), ", ")+")")
+	from := flags.String("from", "", "only queries created at or after this time (RFC 3339 or YYYY-MM-DD)")
+	to := flags.String("to", "", "only queries created before this time (a bare date includes that day)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	values := url.Values{
+		"format":    {*format},
+		"minMargin": {strconv.FormatFloat(*minMargin, 'g', -1, 64)},
+		"humanOnly": {strconv.FormatBool(*humanOnly)},
+		"dedup":     {strconv.FormatBool(*dedup)},
+		"redact":    {*redact},
+		"from":      {*from},
+		"to":        {*to},
+	}
+	options, err := parseExportOptions(values)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export:", err)
+		return 2
+	}
+
+	source, err := openStore()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export:", err)
+		return 1
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "export:", err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+
+	encoder := json.NewEncoder(out)
+	stats, err := exportTrainingData(context.Background(), source, options, func(record interface{}) error {
+		return encoder.Encode(record)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export:", err)
+		return 1
+	}
+
+	statsJSON, _ := json.MarshalIndent(stats, "", "  ")
+	fmt.Fprintln(os.Stderr, string(statsJSON))
+	return 0
+}
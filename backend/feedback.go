@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Per-response ratings
+const (
+	ThumbUp   = "up"
+	ThumbDown = "down"
+)
+
+// Longest comment accepted, in characters
+const MaxFeedbackCommentLength = 2000
+
+// A user's rating of one response
+type ResponseFeedback struct {
+	Index   int    `json:"index"`
+	Agent   string `json:"agent"`
+	Thumb   string `json:"thumb,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// A user's "Pick Best" for a stored query, with optional per-response ratings.
+// Agents lists who produced each response, so feedback can be aggregated without
+// loading the query again.
+type QueryFeedback struct {
+	QueryId   string             `json:"queryId"`
+	BestIndex int                `json:"bestIndex"`
+	Agents    []string           `json:"agents"`
+	Responses []ResponseFeedback `json:"responses,omitempty"`
+	Comment   string             `json:"comment,omitempty"`
+	Rubric    string             `json:"rubric,omitempty"`
+	Timestamp time.Time          `json:"timestamp"`
+}
+
+// How users have rated one agent across every query it answered
+type AgentFeedbackStats struct {
+	Agent       string  `json:"agent"`
+	Appearances int     `json:"appearances"`
+	Picked      int     `json:"picked"`
+	PickRate    float64 `json:"pickRate"`
+	ThumbsUp    int     `json:"thumbsUp"`
+	ThumbsDown  int     `json:"thumbsDown"`
+	Comments    int     `json:"comments"`
+}
+
+// Check feedback against the query it refers to and fill in the agent names
+func validateFeedback(feedback *QueryFeedback, record *QueryRecord) error {
+	if record.Response == nil || len(record.Response.Results) == 0 {
+		return fmt.Errorf("query %q has no responses to rate", record.QueryId)
+	}
+	results := record.Response.Results
+	if feedback.BestIndex < 0 || feedback.BestIndex >= len(results) {
+		return fmt.Errorf("bestIndex %d is out of range for %d responses", feedback.BestIndex, len(results))
+	}
+	if len(feedback.Comment) > MaxFeedbackCommentLength {
+		return fmt.Errorf("comment is longer than %d characters", MaxFeedbackCommentLength)
+	}
+
+	summary := summarizeRecord(record)
+	feedback.Agents = make([]string, len(results))
+	for i, result := range results {
+		feedback.Agents[i] = result.Model
+		if i < len(summary.Agents) {
+			feedback.Agents[i] = summary.Agents[i]
+		}
+	}
+
+	seen := make(map[int]bool)
+	for i := range feedback.Responses {
+		response := &feedback.Responses[i]
+		if response.Index < 0 || response.Index >= len(results) {
+			return fmt.Errorf("responses[%d]: index %d is out of range for %d responses", i, response.Index, len(results))
+		}
+		if seen[response.Index] {
+			return fmt.Errorf("responses[%d]: response %d is rated more than once", i, response.Index)
+		}
+		seen[response.Index] = true
+
+		response.Thumb = strings.ToLower(strings.TrimSpace(response.Thumb))
+		if response.Thumb != "" && response.Thumb != ThumbUp && response.Thumb != ThumbDown {
+			return fmt.Errorf("responses[%d]: thumb must be %q or %q", i, ThumbUp, ThumbDown)
+		}
+		if response.Thumb == "" && strings.TrimSpace(response.Comment) == "" {
+			return fmt.Errorf("responses[%d]: give a thumb, a comment or both", i)
+		}
+		if len(response.Comment) > MaxFeedbackCommentLength {
+			return fmt.Errorf("responses[%d]: comment is longer than %d characters", i, MaxFeedbackCommentLength)
+		}
+		response.Agent = feedback.Agents[response.Index]
+	}
+	sort.Slice(feedback.Responses, func(i, j int) bool {
+		return feedback.Responses[i].Index < feedback.Responses[j].Index
+	})
+	return nil
+}
+
+// Per-agent totals, most picked first
+func aggregateFeedback(feedback []QueryFeedback) []AgentFeedbackStats {
+	byAgent := make(map[string]*AgentFeedbackStats)
+	get := func(agent string) *AgentFeedbackStats {
+		if byAgent[agent] == nil {
+			byAgent[agent] = &AgentFeedbackStats{Agent: agent}
+		}
+		return byAgent[agent]
+	}
+
+	for _, entry := range feedback {
+		for i, agent := range entry.Agents {
+			stats := get(agent)
+			stats.Appearances++
+			if i == entry.BestIndex {
+				stats.Picked++
+			}
+		}
+		for _, response := range entry.Responses {
+			stats := get(response.Agent)
+			switch response.Thumb {
+			case ThumbUp:
+				stats.ThumbsUp++
+			case ThumbDown:
+				stats.ThumbsDown++
+			}
+			if strings.TrimSpace(response.Comment) != "" {
+				stats.Comments++
+			}
+		}
+	}
+
+	aggregated := make([]AgentFeedbackStats, 0, len(byAgent))
+	for _, stats := range byAgent {
+		if stats.Appearances > 0 {
+			stats.PickRate = float64(stats.Picked) / float64(stats.Appearances)
+		}
+		aggregated = append(aggregated, *stats)
+	}
+	sort.Slice(aggregated, func(i, j int) bool {
+		if aggregated[i].Picked != aggregated[j].Picked {
+			return aggregated[i].Picked > aggregated[j].Picked
+		}
+		return aggregated[i].Agent < aggregated[j].Agent
+	})
+	return aggregated
+}
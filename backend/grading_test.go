@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGradeResponse(t *testing.T) {
+	tests := []struct {
+		name          string
+		output        string
+		reference     string
+		method        string
+		tolerance     float64
+		wantCorrect   bool
+		wantExtracted string
+	}{
+		{"exact answer line", "Working...\nAnswer: Paris", "paris", GradeExact, 0, true, "Paris"},
+		{"exact ignores emphasis and punctuation", "The capital is:\n**Paris.**", "Paris", GradeExact, 0, true, "**Paris.**"},
+		{"exact boxed", `so \boxed{42} it is`, "42", GradeExact, 0, true, "42"},
+		{"exact mismatch", "Answer: Lyon", "Paris", GradeExact, 0, false, "Lyon"},
+		{"numeric last number in answer", "Answer: between 3 and 12", "12", GradeNumeric, 0, true, "12"},
+		{"numeric thousands separators", "Final answer: 1,234.5", "1234.5", GradeNumeric, 0, true, "1,234.5"},
+		{"numeric falls back to whole output", "It is 7 apples.\nThat's all!", "7", GradeNumeric, 0, true, "7"},
+		{"numeric within tolerance", "Answer: 2.5", "2", GradeNumeric, 0.5, true, "2.5"},
+		{"numeric outside tolerance", "Answer: 2.5000001", "2", GradeNumeric, 0.5, false, "2.5000001"},
+		{"numeric relative default tolerance", "Answer: 1000000500", "1e9", GradeNumeric, 0, true, "1000000500"},
+		{"numeric beyond relative default tolerance", "Answer: 1000002000", "1e9", GradeNumeric, 0, false, "1000002000"},
+		{"numeric without a number", "No idea", "3", GradeNumeric, 0, false, ""},
+		{"regex match", "The id is AB-1234 here", `[A-Z]{2}-\d{4}`, GradeRegex, 0, true, "AB-1234"},
+		{"regex no match", "The id is ab-12", `[A-Z]{2}-\d{4}`, GradeRegex, 0, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pattern *regexp.Regexp
+			if tt.method == GradeRegex {
+				pattern = regexp.MustCompile(tt.reference)
+			}
+			grade := gradeResponse(context.Background(), "query", tt.output, tt.reference, tt.method, tt.tolerance, pattern)
+			if grade.Correct != tt.wantCorrect {
+				t.Errorf("correct = %v, want %v (%s)", grade.Correct, tt.wantCorrect, grade.Details)
+			}
+			if grade.Extracted != tt.wantExtracted {
+				t.Errorf("extracted = %q, want %q", grade.Extracted, tt.wantExtracted)
+			}
+		})
+	}
+}
+
+func TestNumbersMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		actual    float64
+		expected  float64
+		tolerance float64
+		want      bool
+	}{
+		{"equal", 3, 3, 0, true},
+		{"absolute tolerance is inclusive", 1.25, 1, 0.25, true},
+		{"just past absolute tolerance", 1.2500001, 1, 0.25, false},
+		{"relative tolerance scales with large values", 1e9 + 1000, 1e9, 0, true},
+		{"relative tolerance floor near zero", 1e-7, 0, 0, true},
+		{"small values still need to be close", 1e-5, 0, 0, false},
+		{"sign matters", -2, 2, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := numbersMatch(tt.actual, tt.expected, tt.tolerance); got != tt.want {
+				t.Errorf("numbersMatch(%v, %v, %v) = %v, want %v", tt.actual, tt.expected, tt.tolerance, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGradeResultsInvalidPattern(t *testing.T) {
+	results := []AIResult{{Output: "anything"}, {Error: "timeout"}}
+	gradeResults(context.Background(), "query", results, "(", GradeRegex, 0)
+
+	if results[0].Grade == nil || results[0].Grade.Correct || !strings.Contains(results[0].Grade.Details, "invalid reference pattern") {
+		t.Errorf("grade = %+v, want an invalid pattern error", results[0].Grade)
+	}
+	if results[1].Grade != nil {
+		t.Errorf("failed response was graded: %+v", results[1].Grade)
+	}
+}
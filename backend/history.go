@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes for GET /queries
+const (
+	DefaultHistoryLimit = 20
+	MaxHistoryLimit     = 100
+)
+
+// Which stored queries to list. Agent, Model and Winner must match exactly. Search is split
+// on whitespace and every term must appear, ignoring case, as a plain substring of
+// the query or one of the outputs: no stemming or stopwords, so code identifiers
+// such as "http.Get" match. Every storage backend searches this way.
+type QueryFilter struct {
+	Search   string
+	Agent    string
+	Model    string
+	Winner   string
+	From     *time.Time
+	To       *time.Time
+	HasError *bool
+	Limit    int
+	Offset   int
+}
+
+// One row of the history list; the full response is fetched with GET /queries/:id
+type QuerySummary struct {
+	QueryId     string    `json:"queryId"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Query       string    `json:"query"`
+	Agents      []string  `json:"agents"`
+	Models      []string  `json:"models"`
+	WinnerIndex int       `json:"winnerIndex"`
+	Winner      string    `json:"winner,omitempty"`
+	HasError    bool      `json:"hasError"`
+	ResultCount int       `json:"resultCount"`
+}
+
+type QueryPage struct {
+	Queries []QuerySummary `json:"queries"`
+	Total   int            `json:"total"`
+	Limit   int            `json:"limit"`
+	Offset  int            `json:"offset"`
+}
+
+// Read GET /queries parameters: q, agent, model, winner, from, to, hasError, limit, offset.
+// Dates are RFC 3339 timestamps or YYYY-MM-DD; a bare "to" date includes that whole day.
+func parseQueryFilter(values url.Values) (QueryFilter, error) {
+	filter := QueryFilter{
+		Search: strings.TrimSpace(values.Get("q")),
+		Agent:  values.Get("agent"),
+		Model:  values.Get("model"),
+		Winner: values.Get("winner"),
+		Limit:  DefaultHistoryLimit,
+	}
+
+	if value := values.Get("from"); value != "" {
+		from, err := parseFilterTime(value, false)
+		if err != nil {
+			return filter, fmt.Errorf("from: %v", err)
+		}
+		filter.From = &from
+	}
+	if value := values.Get("to"); value != "" {
+		to, err := parseFilterTime(value, true)
+		if err != nil {
+			return filter, fmt.Errorf("to: %v", err)
+		}
+		filter.To = &to
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return filter, fmt.Errorf("from must be before to")
+	}
+
+	if value := values.Get("hasError"); value != "" {
+		hasError, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("hasError must be true or false")
+		}
+		filter.HasError = &hasError
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxHistoryLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", MaxHistoryLimit)
+		}
+		filter.Limit = limit
+	}
+	if value := values.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("offset must be a non-negative integer")
+		}
+		filter.Offset = offset
+	}
+	return filter, nil
+}
+
+// Returns the instant the filter bound refers to; "to" bounds are exclusive
+func parseFilterTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 timestamp or YYYY-MM-DD, got %q", value)
+	}
+	if endOfDay {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
+
+func summarizeRecord(record *QueryRecord) QuerySummary {
+	summary := QuerySummary{
+		QueryId:     record.QueryId,
+		CreatedAt:   record.CreatedAt,
+		Query:       record.Request.Query,
+		Agents:      make([]string, len(record.Request.Agents)),
+		Models:      make([]string, 0),
+		WinnerIndex: -1,
+	}
+	for i, agent := range record.Request.Agents {
+		summary.Agents[i] = agent.Name
+	}
+
+	response := record.Response
+	if response == nil {
+		return summary
+	}
+	summary.ResultCount = len(response.Results)
+	for _, result := range response.Results {
+		if !containsString(summary.Models, result.Model) {
+			summary.Models = append(summary.Models, result.Model)
+		}
+		if result.Error != "" {
+			summary.HasError = true
+		}
+	}
+
+	// Results are in agent order, so the winning index names the agent too
+	if evaluation := response.MasterEvaluation; evaluation != nil {
+		best := evaluation.BestResponseIndex
+		if best >= 0 && best < len(response.Results) {
+			summary.WinnerIndex = best
+			summary.Winner = response.Results[best].Model
+			if best < len(summary.Agents) {
+				summary.Winner = summary.Agents[best]
+			}
+		}
+	}
+	return summary
+}
+
+// Text searched by the q parameter: the query and every output
+func recordSearchText(record *QueryRecord) string {
+	parts := []string{record.Request.Query}
+	if record.Response != nil {
+		for _, result := range record.Response.Results {
+			parts = append(parts, result.Output)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (f QueryFilter) matches(summary QuerySummary, searchText string) bool {
+	if f.Agent != "" && !containsString(summary.Agents, f.Agent) {
+		return false
+	}
+	if f.Model != "" && !containsString(summary.Models, f.Model) {
+		return false
+	}
+	if f.Winner != "" && summary.Winner != f.Winner {
+		return false
+	}
+	if f.From != nil && summary.CreatedAt.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && !summary.CreatedAt.Before(*f.To) {
+		return false
+	}
+	if f.HasError != nil && summary.HasError != *f.HasError {
+		return false
+	}
+	if f.Search != "" {
+		text := strings.ToLower(searchText)
+		for _, term := range searchTerms(f.Search) {
+			if !strings.Contains(text, term) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Lower-cased whitespace-separated terms of a search, each matched as a substring
+func searchTerms(search string) []string {
+	return strings.Fields(strings.ToLower(search))
+}
+
+// Newest first, then cut to the requested page
+func paginateSummaries(summaries []QuerySummary, filter QueryFilter) *QueryPage {
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].CreatedAt.After(summaries[j].CreatedAt)
+	})
+
+	page := &QueryPage{Queries: make([]QuerySummary, 0), Total: len(summaries), Limit: filter.Limit, Offset: filter.Offset}
+	if filter.Offset < len(summaries) {
+		end := filter.Offset + filter.Limit
+		if end > len(summaries) {
+			end = len(summaries)
+		}
+		page.Queries = append(page.Queries, summaries[filter.Offset:end]...)
+	}
+	return page
+}
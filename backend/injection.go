@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Patterns in agent output that address the evaluator rather than the user
+var injectionPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"ignore-instructions", regexp.MustCompile(`(?i)\b(ignore|disregard|forget)\b.{0,30}\b(previous|prior|above|earlier|all)\b.{0,20}\b(instructions?|prompts?|rules|criteria)\b`)},
+	{"verdict-line", regexp.MustCompile(`(?im)^\W*(BEST|RANKINGS)\s*:\s*\[?\d`)},
+	{"evaluator-address", regexp.MustCompile(`(?i)\b(dear|attention|note to (the)?)\s*(ai\s+)?(evaluator|judge|grader|assessor)\b`)},
+	{"role-override", regexp.MustCompile(`(?i)\byou are (now )?(the|an|a) (expert )?(ai )?(evaluator|judge|grader)\b`)},
+	{"self-promotion", regexp.MustCompile(`(?i)\b(select|choose|pick|rank|rate)\s+(this|my)\s+(response|answer)\b.{0,30}\b(best|first|highest|winner|top)\b`)},
+	{"system-prompt", regexp.MustCompile(`(?i)(\bsystem prompt\b|<\|im_start\|>|\[/?INST\]|<\|system\|>)`)},
+}
+
+// Lines starting with these would look like part of the judge's verdict
+var verdictPrefixPattern = regexp.MustCompile(`(?im)^(\s*)(BEST|REASONING|RANKINGS|VERDICT)(\w*\s*:)`)
+
+// Flag agent outputs that contain instructions directed at the judge
+func detectInjection(results []AIResult) {
+	for i := range results {
+		if results[i].Error != "" {
+			continue
+		}
+		results[i].InjectionFlags = findInjectionFlags(results[i].Output)
+	}
+}
+
+func findInjectionFlags(output string) []string {
+	var flags []string
+	for _, p := range injectionPatterns {
+		if p.pattern.MatchString(output) {
+			flags = append(flags, p.name)
+		}
+	}
+	return flags
+}
+
+// Random token that candidate content cannot predict, used to delimit candidates and the verdict
+func newBoundaryToken() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand does not fail on supported platforms; keep the prompt usable if it does
+		return "HIVEMIND"
+	}
+	return strings.ToUpper(hex.EncodeToString(buf))
+}
+
+func candidateOpenTag(boundary string, number int) string {
+	return fmt.Sprintf("<<<CANDIDATE_%s #%d>>>", boundary, number)
+}
+
+func candidateCloseTag(boundary string) string {
+	return fmt.Sprintf("<<<END_CANDIDATE_%s>>>", boundary)
+}
+
+func verdictMarker(boundary string) string {
+	return "VERDICT_" + boundary
+}
+
+// Wrap candidate content in boundary tags, neutralizing anything that could pass
+// for a tag or a verdict line
+func delimitCandidate(content, boundary string, number int) string {
+	escaped := strings.ReplaceAll(content, "<<<", "< < <")
+	escaped = strings.ReplaceAll(escaped, ">>>", "> > >")
+	escaped = verdictPrefixPattern.ReplaceAllString(escaped, "${1}| ${2}${3}")
+	return fmt.Sprintf("%s\n%s\n%s", candidateOpenTag(boundary, number), escaped, candidateCloseTag(boundary))
+}
+
+// Return only the part of the judge's output that can hold a genuine verdict:
+// text after the last verdict marker if present, otherwise everything outside
+// echoed candidate regions
+func extractVerdict(evaluation, boundary string) string {
+	marker := verdictMarker(boundary)
+	if idx := strings.LastIndex(evaluation, marker); idx >= 0 {
+		return evaluation[idx+len(marker):]
+	}
+
+	openPrefix := "<<<CANDIDATE_" + boundary
+	closeTag := candidateCloseTag(boundary)
+
+	var outside strings.Builder
+	rest := evaluation
+	for {
+		start := strings.Index(rest, openPrefix)
+		if start < 0 {
+			outside.WriteString(rest)
+			break
+		}
+		outside.WriteString(rest[:start])
+
+		end := strings.Index(rest[start:], closeTag)
+		if end < 0 {
+			break // Unterminated candidate region: nothing after it is trusted
+		}
+		rest = rest[start+end+len(closeTag):]
+	}
+
+	return outside.String()
+}
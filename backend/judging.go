@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"unicode/utf8"
+)
+
+// Rough prompt size estimate; local tokenizers average about four characters per token
+const ApproxCharsPerToken = 4
+
+// Share of the prompt budget a query may take in bracket judging; the rest is for candidates
+const MaxBracketQueryShare = 0.5
+
+// Context window of the judge model, overridable with HIVEMIND_JUDGE_CONTEXT_TOKENS
+var judgeContextTokens = JudgeContextTokens
+
+// One judging call in a batched evaluation. Candidates and Winner are original result indices.
+type JudgingRound struct {
+	Round      int    `json:"round"`
+	Group      int    `json:"group"`
+	Candidates []int  `json:"candidates"`
+	Winner     int    `json:"winner"`
+	Reasoning  string `json:"reasoning,omitempty"`
+}
+
+func estimateTokens(text string) int {
+	return (len(text) + ApproxCharsPerToken - 1) / ApproxCharsPerToken
+}
+
+// Tokens available for the evaluation prompt once the judge's answer is reserved
+func judgePromptBudget() int {
+	return judgeContextTokens - MaxResponseTokens
+}
+
+func fitsJudgeContext(query string, candidates []AIResult) bool {
+	return estimateTokens(buildEvaluationPrompt(query, candidates, newBoundaryToken())) <= judgePromptBudget()
+}
+
+// Master evaluation parameters: low temperature for consistent judging
+func masterJudgeParams() WorkerParams {
+	return WorkerParams{
+		Temperature: 0.1,
+		TopK:        30,
+		TopP:        0.8,
+		WorkerID:    "Master",
+	}
+}
+
+// Judge candidates in a single prompt. Returns false if the judge could not be reached.
+func judgeCandidates(ctx context.Context, query string, candidates []AIResult, indices []int) (*MasterEvaluation, bool) {
+	// Candidates are delimited with a per-evaluation random boundary they cannot forge
+	boundary := newBoundaryToken()
+	evaluationPrompt := buildEvaluationPrompt(query, candidates, boundary)
+
+	evalResult := callQwenWorker(ctx, evaluationPrompt, masterJudgeParams())
+	if evalResult.Error != "" {
+		return nil, false
+	}
+
+	// Parse evaluation result with proper index mapping
+	verdict := extractVerdict(evalResult.Output, boundary)
+	return parseEvaluationResultWithMapping(verdict, candidates, indices, 0), true
+}
+
+// Judge candidates that don't fit one prompt: split them into groups that fit,
+// judge each group, then judge the group winners, repeating until one prompt suffices
+func judgeInBracket(ctx context.Context, query string, candidates []AIResult, indices []int) (*MasterEvaluation, bool) {
+	query = truncateQueryForBracket(query)
+	candidates = truncateForBracket(query, candidates)
+
+	bracket := make([]JudgingRound, 0)
+	order, final, ok := rankBracket(ctx, query, candidates, indices, 1, &bracket)
+	if !ok {
+		return nil, false
+	}
+
+	rankings := make([]ResponseRanking, len(order))
+	for position, index := range order {
+		rankings[position] = ResponseRanking{
+			Index:     index,
+			Score:     calculatePositionalScore(position, len(order)),
+			Reasoning: fmt.Sprintf("Ranked #%d by bracket evaluation", position+1),
+		}
+	}
+
+	evaluation := &MasterEvaluation{
+		BestResponseIndex: order[0],
+		Reasoning:         final.Reasoning,
+		Rankings:          rankings,
+		Bracket:           bracket,
+		Verdict:           final.Verdict,
+		TiedIndices:       final.TiedIndices,
+	}
+
+	// The final round decides whether anything is acceptable at all
+	if final.BestResponseIndex < 0 {
+		evaluation.BestResponseIndex = -1
+	}
+
+	return evaluation, true
+}
+
+// Produce a full ordering of the candidates (original indices, best first) and the
+// evaluation from the deciding round
+func rankBracket(ctx context.Context, query string, candidates []AIResult, indices []int, round int, bracket *[]JudgingRound) ([]int, *MasterEvaluation, bool) {
+	if fitsJudgeContext(query, candidates) {
+		evaluation, ok := judgeCandidates(ctx, query, candidates, indices)
+		if !ok {
+			return nil, nil, false
+		}
+		return completeOrder(evaluation, indices), evaluation, true
+	}
+
+	// A round that cannot shrink the field would repeat forever; let the caller fall back
+	groups := splitIntoBatches(query, candidates)
+	if len(groups) >= len(candidates) {
+		log.Printf("Bracket judging cannot split %d candidates into smaller groups, falling back to heuristic ranking", len(candidates))
+		return nil, nil, false
+	}
+
+	groupOrders := make([][]int, len(groups))
+	winners := make([]AIResult, 0, len(groups))
+	winnerIndices := make([]int, 0, len(groups))
+	winnerGroup := make(map[int]int)
+
+	for g, group := range groups {
+		groupCandidates := make([]AIResult, len(group))
+		groupIndices := make([]int, len(group))
+		for i, position := range group {
+			groupCandidates[i] = candidates[position]
+			groupIndices[i] = indices[position]
+		}
+
+		entry := JudgingRound{Round: round, Group: g + 1, Candidates: groupIndices}
+		if len(group) == 1 {
+			groupOrders[g] = groupIndices
+			entry.Reasoning = "Advanced without judging (only candidate in group)"
+		} else {
+			evaluation, ok := judgeCandidates(ctx, query, groupCandidates, groupIndices)
+			if !ok {
+				return nil, nil, false
+			}
+			groupOrders[g] = completeOrder(evaluation, groupIndices)
+			entry.Reasoning = evaluation.Reasoning
+		}
+		entry.Winner = groupOrders[g][0]
+		*bracket = append(*bracket, entry)
+
+		winners = append(winners, groupCandidates[indexOf(groupIndices, entry.Winner)])
+		winnerIndices = append(winnerIndices, entry.Winner)
+		winnerGroup[entry.Winner] = g
+	}
+
+	winnerOrder, final, ok := rankBracket(ctx, query, winners, winnerIndices, round+1, bracket)
+	if !ok {
+		return nil, nil, false
+	}
+
+	if len(*bracket) == 0 || (*bracket)[len(*bracket)-1].Round == round {
+		// The winners fit one prompt, so record that final call as its own round
+		*bracket = append(*bracket, JudgingRound{
+			Round:      round + 1,
+			Group:      1,
+			Candidates: winnerIndices,
+			Winner:     winnerOrder[0],
+			Reasoning:  final.Reasoning,
+		})
+	}
+
+	// Winners in final order, then runners-up level by level, following their winner's finishing position
+	order := append([]int{}, winnerOrder...)
+	for level := 1; ; level++ {
+		added := false
+		for _, winner := range winnerOrder {
+			groupOrder := groupOrders[winnerGroup[winner]]
+			if level < len(groupOrder) {
+				order = append(order, groupOrder[level])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+
+	return order, final, true
+}
+
+// Greedily fill groups with consecutive candidates while the prompt still fits
+func splitIntoBatches(query string, candidates []AIResult) [][]int {
+	groups := make([][]int, 0)
+	current := make([]int, 0)
+	currentCandidates := make([]AIResult, 0)
+
+	for i, candidate := range candidates {
+		tentative := append(append([]AIResult{}, currentCandidates...), candidate)
+		if len(current) > 0 && !fitsJudgeContext(query, tentative) {
+			groups = append(groups, current)
+			current = make([]int, 0)
+			currentCandidates = make([]AIResult, 0)
+		}
+		current = append(current, i)
+		currentCandidates = append(currentCandidates, candidate)
+	}
+	if len(current) > 0 {
+		groups = append(groups, current)
+	}
+
+	return groups
+}
+
+// Cap the query so the prompt keeps room for candidates however long the query is
+func truncateQueryForBracket(query string) string {
+	maxChars := int(float64(judgePromptBudget())*MaxBracketQueryShare) * ApproxCharsPerToken
+	return truncateForJudge(query, maxChars)
+}
+
+// Marker appended to text cut short for the judge
+const truncationNote = "\n[... truncated for evaluation]"
+
+// Cap every candidate so that any two fit in one prompt, which guarantees each
+// bracket round at least halves the field. A candidate's label, annotations and
+// escaping count against its share, not just its output.
+func truncateForBracket(query string, candidates []AIResult) []AIResult {
+	boundary := newBoundaryToken()
+	base := len(buildEvaluationPrompt(query, nil, boundary))
+	share := (judgePromptBudget()*ApproxCharsPerToken - base) / 2
+
+	truncated := make([]AIResult, len(candidates))
+	for i, candidate := range candidates {
+		truncated[i] = candidate
+		maxChars := len(candidate.Output)
+		for {
+			excess := len(buildEvaluationPrompt(query, truncated[i:i+1], boundary)) - base - share
+			if excess <= 0 || maxChars == 0 {
+				break
+			}
+			maxChars -= excess + len(truncationNote)
+			if maxChars < 0 {
+				maxChars = 0
+			}
+			truncated[i].Output = truncateForJudge(candidate.Output, maxChars)
+		}
+	}
+	return truncated
+}
+
+func truncateForJudge(text string, maxChars int) string {
+	if len(text) <= maxChars {
+		return text
+	}
+	// Cut on a rune boundary so the judge never sees a broken character
+	for maxChars > 0 && !utf8.RuneStart(text[maxChars]) {
+		maxChars--
+	}
+	return text[:maxChars] + truncationNote
+}
+
+// Turn a possibly partial evaluation into a full ordering: the best response,
+// then the judge's ranking, then any candidates the judge left out
+func completeOrder(evaluation *MasterEvaluation, indices []int) []int {
+	order := make([]int, 0, len(indices))
+	seen := make(map[int]bool)
+	add := func(index int) {
+		if !seen[index] && indexOf(indices, index) >= 0 {
+			seen[index] = true
+			order = append(order, index)
+		}
+	}
+
+	add(evaluation.BestResponseIndex)
+	for _, ranking := range evaluation.Rankings {
+		add(ranking.Index)
+	}
+	for _, index := range indices {
+		add(index)
+	}
+	return order
+}
+
+func indexOf(values []int, target int) int {
+	for i, v := range values {
+		if v == target {
+			return i
+		}
+	}
+	return -1
+}
+
+func validateJudgeStrategy(strategy string) error {
+	switch strategy {
+	case "", StrategyJudge, StrategyBracket, StrategyHeuristic:
+		return nil
+	}
+	return fmt.Errorf("unknown strategy %q (use %s, %s or %s)", strategy, StrategyJudge, StrategyBracket, StrategyHeuristic)
+}
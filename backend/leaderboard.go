@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TrueSkill defaults: every agent starts at Mu with uncertainty Sigma; Beta is the
+// performance noise of a single match and Tau keeps ratings able to move over time
+const (
+	RatingMu    = 25.0
+	RatingSigma = RatingMu / 3
+	RatingBeta  = RatingSigma / 2
+	RatingTau   = RatingSigma / 100
+)
+
+// Where a match outcome came from
+const (
+	MatchSourceMaster = "master"
+	MatchSourceHuman  = "human"
+)
+
+// Master ranking scores closer than this are treated as a tie and not counted
+const RankingTieMargin = 0.01
+
+// Sampling parameter bands used to group agent configurations
+const (
+	LowTemperatureMax  = 0.5
+	HighTemperatureMin = 0.9
+	LowTopKMax         = 35
+	HighTopKMin        = 60
+	LowTopPMax         = 0.8
+	HighTopPMin        = 0.9
+)
+
+// What the leaderboard rates: an agent as configured for one query
+type AgentIdentity struct {
+	Name           string `json:"name"`
+	Specialization string `json:"specialization,omitempty"`
+	Model          string `json:"model"`
+	ParamBucket    string `json:"paramBucket"`
+}
+
+type LeaderboardEntry struct {
+	Identity AgentIdentity `json:"identity"`
+
+	// Conservative skill estimate (Mu - 3*Sigma); entries are ranked by it
+	Rating       float64 `json:"rating"`
+	Mu           float64 `json:"mu"`
+	Sigma        float64 `json:"sigma"`
+	IntervalLow  float64 `json:"intervalLow"`
+	IntervalHigh float64 `json:"intervalHigh"`
+
+	Matches       int       `json:"matches"`
+	Wins          int       `json:"wins"`
+	Losses        int       `json:"losses"`
+	WinRate       float64   `json:"winRate"`
+	MasterMatches int       `json:"masterMatches"`
+	HumanMatches  int       `json:"humanMatches"`
+	LastPlayed    time.Time `json:"lastPlayed"`
+}
+
+type LeaderboardReport struct {
+	Domain    string                        `json:"domain,omitempty"`
+	Entries   []LeaderboardEntry            `json:"entries"`
+	ByDomain  map[string][]LeaderboardEntry `json:"byDomain,omitempty"`
+	Matches   int                           `json:"matches"`
+	UpdatedAt time.Time                     `json:"updatedAt"`
+}
+
+// One pairwise result between two responses to the same query
+type ratingMatch struct {
+	winner int
+	loser  int
+}
+
+type ratingTable map[string]*LeaderboardEntry
+
+type leaderboardStore struct {
+	mu        sync.Mutex
+	overall   ratingTable
+	byDomain  map[string]ratingTable
+	matches   int
+	updatedAt time.Time
+}
+
+var leaderboard = newLeaderboardStore()
+
+func newLeaderboardStore() *leaderboardStore {
+	return &leaderboardStore{overall: make(ratingTable), byDomain: make(map[string]ratingTable)}
+}
+
+func (id AgentIdentity) key() string {
+	return strings.Join([]string{id.Name, id.Specialization, id.Model, id.ParamBucket}, "|")
+}
+
+// Band each sampling parameter as low, mid or high, e.g. "t:mid,k:low,p:high"
+func paramBucket(params WorkerParams) string {
+	band := func(value, lowMax, highMin float64) string {
+		switch {
+		case value < lowMax:
+			return "low"
+		case value >= highMin:
+			return "high"
+		}
+		return "mid"
+	}
+	return fmt.Sprintf("t:%s,k:%s,p:%s",
+		band(params.Temperature, LowTemperatureMax, HighTemperatureMin),
+		band(float64(params.TopK), LowTopKMax, HighTopKMin),
+		band(params.TopP, LowTopPMax, HighTopPMin))
+}
+
+// Identity of the agent behind each result, in result order
+func agentIdentities(record *QueryRecord) []AgentIdentity {
+	if record.Response == nil {
+		return nil
+	}
+	identities := make([]AgentIdentity, len(record.Response.Results))
+	for i, result := range record.Response.Results {
+		identity := AgentIdentity{Name: result.Model, Model: QwenModel}
+		params := WorkerParams{}
+		if i < len(record.Request.Agents) {
+			agent := record.Request.Agents[i]
+			identity.Name = agent.Name
+			identity.Specialization = agent.Specialization
+			params = agent.WorkerParams
+		}
+		if result.WorkerParams != nil {
+			params = *result.WorkerParams
+		}
+		identity.ParamBucket = paramBucket(params)
+		identities[i] = identity
+	}
+	return identities
+}
+
+// Every pair the master ranked apart; failed responses take no part
+func masterMatches(response *QueryResponse) []ratingMatch {
+	evaluation := response.MasterEvaluation
+	if evaluation == nil || evaluation.Strategy == StrategySingle || len(evaluation.Rankings) < 2 {
+		return nil
+	}
+
+	scores := make(map[int]float64)
+	for _, ranking := range evaluation.Rankings {
+		if ranking.Index >= 0 && ranking.Index < len(response.Results) && response.Results[ranking.Index].Error == "" {
+			scores[ranking.Index] = ranking.Score
+		}
+	}
+
+	matches := make([]ratingMatch, 0)
+	for i, a := range scores {
+		for j, b := range scores {
+			if i < j && math.Abs(a-b) >= RankingTieMargin {
+				if a > b {
+					matches = append(matches, ratingMatch{winner: i, loser: j})
+				} else {
+					matches = append(matches, ratingMatch{winner: j, loser: i})
+				}
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].winner != matches[j].winner {
+			return matches[i].winner < matches[j].winner
+		}
+		return matches[i].loser < matches[j].loser
+	})
+	return matches
+}
+
+// The user's pick beats every other response
+func humanMatches(feedback QueryFeedback, candidates int) []ratingMatch {
+	matches := make([]ratingMatch, 0, candidates)
+	for i := 0; i < candidates; i++ {
+		if i != feedback.BestIndex {
+			matches = append(matches, ratingMatch{winner: feedback.BestIndex, loser: i})
+		}
+	}
+	return matches
+}
+
+func (l *leaderboardStore) recordQuery(record *QueryRecord) {
+	if record.Response == nil || servedFromCache(record) {
+		return
+	}
+	l.apply(classifyDomain(record.Request), agentIdentities(record), masterMatches(record.Response), MatchSourceMaster, record.CreatedAt)
+}
+
+func (l *leaderboardStore) recordFeedback(feedback QueryFeedback, record *QueryRecord) {
+	identities := agentIdentities(record)
+	l.apply(classifyDomain(record.Request), identities, humanMatches(feedback, len(identities)), MatchSourceHuman, feedback.Timestamp)
+}
+
+func (l *leaderboardStore) apply(domain string, identities []AgentIdentity, matches []ratingMatch, source string, at time.Time) {
+	if len(matches) == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.byDomain[domain] == nil {
+		l.byDomain[domain] = make(ratingTable)
+	}
+	for _, match := range matches {
+		winner, loser := identities[match.winner], identities[match.loser]
+		if winner.key() == loser.key() {
+			continue
+		}
+		for _, table := range []ratingTable{l.overall, l.byDomain[domain]} {
+			playMatch(table.entry(winner), table.entry(loser), source, at)
+		}
+		l.matches++
+	}
+	l.updatedAt = time.Now()
+}
+
+func (t ratingTable) entry(identity AgentIdentity) *LeaderboardEntry {
+	key := identity.key()
+	if t[key] == nil {
+		t[key] = &LeaderboardEntry{Identity: identity, Mu: RatingMu, Sigma: RatingSigma}
+	}
+	return t[key]
+}
+
+// Two-player TrueSkill update without draws
+func playMatch(winner, loser *LeaderboardEntry, source string, at time.Time) {
+	winnerVariance := winner.Sigma*winner.Sigma + RatingTau*RatingTau
+	loserVariance := loser.Sigma*loser.Sigma + RatingTau*RatingTau
+	c := math.Sqrt(2*RatingBeta*RatingBeta + winnerVariance + loserVariance)
+	t := (winner.Mu - loser.Mu) / c
+
+	// v and w are the mean and variance corrections of a truncated Gaussian
+	v := -t
+	if cdf := 0.5 * math.Erfc(-t/math.Sqrt2); cdf > 1e-12 {
+		v = math.Exp(-t*t/2) / math.Sqrt(2*math.Pi) / cdf
+	}
+	w := v * (v + t)
+
+	winner.Mu += winnerVariance / c * v
+	loser.Mu -= loserVariance / c * v
+	winner.Sigma = math.Sqrt(winnerVariance * math.Max(1-winnerVariance/(c*c)*w, 1e-6))
+	loser.Sigma = math.Sqrt(loserVariance * math.Max(1-loserVariance/(c*c)*w, 1e-6))
+
+	winner.Wins++
+	loser.Losses++
+	for _, entry := range []*LeaderboardEntry{winner, loser} {
+		entry.Matches++
+		if source == MatchSourceHuman {
+			entry.HumanMatches++
+		} else {
+			entry.MasterMatches++
+		}
+		if at.After(entry.LastPlayed) {
+			entry.LastPlayed = at
+		}
+	}
+}
+
+// Replay stored queries and feedback in time order so ratings survive restarts
+func (l *leaderboardStore) rebuild(ctx context.Context, store Store) error {
+	type ratingEvent struct {
+		at         time.Time
+		domain     string
+		identities []AgentIdentity
+		matches    []ratingMatch
+		source     string
+	}
+
+	events := make([]ratingEvent, 0)
+	queries := make(map[string]ratingEvent)
+	err := store.ScanQueries(ctx, func(record *QueryRecord) error {
+		if record.Response == nil {
+			return nil
+		}
+		event := ratingEvent{
+			at:         record.CreatedAt,
+			domain:     classifyDomain(record.Request),
+			identities: agentIdentities(record),
+			matches:    masterMatches(record.Response),
+			source:     MatchSourceMaster,
+		}
+		queries[record.QueryId] = event
+		if !servedFromCache(record) {
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	feedback, err := store.ListFeedback(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, entry := range feedback {
+		query, ok := queries[entry.QueryId]
+		if !ok || entry.BestIndex >= len(query.identities) {
+			continue
+		}
+		events = append(events, ratingEvent{
+			at:         entry.Timestamp,
+			domain:     query.domain,
+			identities: query.identities,
+			matches:    humanMatches(entry, len(query.identities)),
+			source:     MatchSourceHuman,
+		})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+	rebuilt := newLeaderboardStore()
+	for _, event := range events {
+		rebuilt.apply(event.domain, event.identities, event.matches, event.source, event.at)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.overall, l.byDomain, l.matches, l.updatedAt = rebuilt.overall, rebuilt.byDomain, rebuilt.matches, rebuilt.updatedAt
+	return nil
+}
+
+// Ratings for one domain, or overall with a per-domain breakdown when domain is empty
+func (l *leaderboardStore) report(domain string, minMatches int) *LeaderboardReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	report := &LeaderboardReport{Domain: domain, Matches: l.matches, UpdatedAt: l.updatedAt}
+	if domain != "" {
+		report.Entries = l.byDomain[domain].ranked(minMatches)
+		return report
+	}
+
+	report.Entries = l.overall.ranked(minMatches)
+	report.ByDomain = make(map[string][]LeaderboardEntry, len(l.byDomain))
+	for name, table := range l.byDomain {
+		report.ByDomain[name] = table.ranked(minMatches)
+	}
+	return report
+}
+
+// Copies of the entries with at least minMatches matches, best first
+func (t ratingTable) ranked(minMatches int) []LeaderboardEntry {
+	entries := make([]LeaderboardEntry, 0, len(t))
+	for _, entry := range t {
+		if entry.Matches < minMatches {
+			continue
+		}
+		ranked := *entry
+		ranked.Rating = entry.Mu - 3*entry.Sigma
+		ranked.IntervalLow = entry.Mu - 1.96*entry.Sigma
+		ranked.IntervalHigh = entry.Mu + 1.96*entry.Sigma
+		if entry.Matches > 0 {
+			ranked.WinRate = float64(entry.Wins) / float64(entry.Matches)
+		}
+		entries = append(entries, ranked)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Rating != entries[j].Rating {
+			return entries[i].Rating > entries[j].Rating
+		}
+		return entries[i].Identity.key() < entries[j].Identity.key()
+	})
+	return entries
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBestVerdict(t *testing.T) {
+	validIndices := []int{0, 4, 7}
+
+	tests := []struct {
+		name        string
+		value       string
+		wantVerdict string
+		wantChosen  []int
+	}{
+		{"single pick", " 2", VerdictBest, []int{4}},
+		{"bracketed pick", " [3]", VerdictBest, []int{7}},
+		{"tie", " 1=3", VerdictTie, []int{0, 7}},
+		{"tie with spaces", " 2 = 1", VerdictTie, []int{4, 0}},
+		{"repeated number is not a tie", " 2=2", VerdictBest, []int{4}},
+		{"none acceptable", " NONE", VerdictNoneAcceptable, nil},
+		{"none lower case", " none of them", VerdictNoneAcceptable, nil},
+		{"abstain", " ABSTAIN", VerdictAbstain, nil},
+		{"out of range", " 4", VerdictBest, []int{}},
+		{"zero", " 0", VerdictBest, []int{}},
+		{"garbage", " the second one", VerdictBest, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, chosen := parseBestVerdict(tt.value, validIndices)
+			if verdict != tt.wantVerdict {
+				t.Errorf("verdict = %q, want %q", verdict, tt.wantVerdict)
+			}
+			if !reflect.DeepEqual(chosen, tt.wantChosen) {
+				t.Errorf("chosen = %v, want %v", chosen, tt.wantChosen)
+			}
+		})
+	}
+}
+
+func TestParseRankingsWithMapping(t *testing.T) {
+	validIndices := []int{2, 5, 6}
+
+	tests := []struct {
+		name        string
+		rankStr     string
+		wantIndices []int
+		wantTied    []bool
+	}{
+		{"full order", "2,1,3", []int{5, 2, 6}, []bool{false, false, false}},
+		{"tie in the middle", "3, 1=2", []int{6, 2, 5}, []bool{false, true, true}},
+		{"duplicates kept once", "1,1,2", []int{2, 5}, []bool{false, false}},
+		{"out of range dropped", "4,1,0", []int{2}, []bool{false}},
+		{"empty", "", []int{}, []bool{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rankings := parseRankingsWithMapping(tt.rankStr, validIndices)
+			indices := make([]int, len(rankings))
+			tied := make([]bool, len(rankings))
+			for i, ranking := range rankings {
+				indices[i] = ranking.Index
+				tied[i] = strings.HasPrefix(ranking.Reasoning, "Tied")
+			}
+			if !reflect.DeepEqual(indices, tt.wantIndices) {
+				t.Errorf("indices = %v, want %v", indices, tt.wantIndices)
+			}
+			if !reflect.DeepEqual(tied, tt.wantTied) {
+				t.Errorf("tied = %v, want %v", tied, tt.wantTied)
+			}
+		})
+	}
+}
+
+func TestParseRankingsWithMappingTiesShareScore(t *testing.T) {
+	rankings := parseRankingsWithMapping("1=2,3", []int{0, 1, 2})
+	if len(rankings) != 3 {
+		t.Fatalf("got %d rankings, want 3", len(rankings))
+	}
+	if rankings[0].Score != rankings[1].Score {
+		t.Errorf("tied scores differ: %v and %v", rankings[0].Score, rankings[1].Score)
+	}
+	if rankings[2].Score >= rankings[1].Score {
+		t.Errorf("third place scored %v, not below tied %v", rankings[2].Score, rankings[1].Score)
+	}
+}
+
+func TestCompleteRankings(t *testing.T) {
+	validIndices := []int{1, 3, 4, 8}
+
+	tests := []struct {
+		name     string
+		rankings []ResponseRanking
+		want     []int
+	}{
+		{"nothing ranked", nil, []int{1, 3, 4, 8}},
+		{"partial ranking keeps judge order first", []ResponseRanking{{Index: 4}, {Index: 1}}, []int{4, 1, 3, 8}},
+		{"complete ranking unchanged", []ResponseRanking{{Index: 8}, {Index: 4}, {Index: 3}, {Index: 1}}, []int{8, 4, 3, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rankings := completeRankings(tt.rankings, validIndices)
+			got := make([]int, len(rankings))
+			for i, ranking := range rankings {
+				got[i] = ranking.Index
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+			for i := len(tt.rankings); i < len(rankings); i++ {
+				if want := calculatePositionalScore(i, len(validIndices)); rankings[i].Score != want {
+					t.Errorf("appended ranking %d scored %v, want %v", i, rankings[i].Score, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseEvaluationResultWithMapping(t *testing.T) {
+	// Cluster judging shows only representatives, so candidate numbers map to sparse indices
+	validIndices := []int{0, 4}
+	candidates := make([]AIResult, len(validIndices))
+
+	tests := []struct {
+		name        string
+		evaluation  string
+		wantBest    int
+		wantVerdict string
+		wantTied    []int
+	}{
+		{"pick maps to original index", "BEST: 2\nREASONING: better\nRANKINGS: 2,1", 4, VerdictBest, nil},
+		{"missing best falls back to top ranking", "REASONING: hmm\nRANKINGS: 2,1", 4, VerdictBest, nil},
+		{"tie", "BEST: 1=2\nRANKINGS: 1=2", 0, VerdictTie, []int{0, 4}},
+		{"none acceptable", "BEST: NONE\nRANKINGS: 1,2", -1, VerdictNoneAcceptable, nil},
+		{"abstain", "BEST: ABSTAIN", -1, VerdictAbstain, nil},
+		{"unparseable", "I like the second one", 0, VerdictBest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluation := parseEvaluationResultWithMapping(tt.evaluation, candidates, validIndices, 0)
+			if evaluation.BestResponseIndex != tt.wantBest {
+				t.Errorf("best = %d, want %d", evaluation.BestResponseIndex, tt.wantBest)
+			}
+			if evaluation.Verdict != tt.wantVerdict {
+				t.Errorf("verdict = %q, want %q", evaluation.Verdict, tt.wantVerdict)
+			}
+			if !reflect.DeepEqual(evaluation.TiedIndices, tt.wantTied) {
+				t.Errorf("tied = %v, want %v", evaluation.TiedIndices, tt.wantTied)
+			}
+			if len(evaluation.Rankings) != len(validIndices) {
+				t.Errorf("got %d rankings, want one per candidate", len(evaluation.Rankings))
+			}
+		})
+	}
+}
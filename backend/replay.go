@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// What a replay re-runs
+const (
+	ReplayGeneration = "generation"
+	ReplayEvaluation = "evaluation"
+)
+
+// Overrides is a partial QueryRequest laid over the stored one, so a replay only
+// names what it changes, e.g. {"judgeStrategy": "bracket"} or {"agents": [...]}
+type ReplayRequest struct {
+	Mode      string          `json:"mode"`
+	Overrides json.RawMessage `json:"overrides,omitempty"`
+}
+
+type ReplayResult struct {
+	QueryId  string         `json:"queryId"`
+	Mode     string         `json:"mode"`
+	Request  QueryRequest   `json:"request"`
+	Response *QueryResponse `json:"response"`
+	Diff     *ReplayDiff    `json:"diff"`
+}
+
+// How the replay's outcome differs from the stored one. Responses are matched by
+// agent name, since a new team may list agents in a different order.
+type ReplayDiff struct {
+	OriginalWinner      string        `json:"originalWinner,omitempty"`
+	ReplayWinner        string        `json:"replayWinner,omitempty"`
+	WinnerChanged       bool          `json:"winnerChanged"`
+	OriginalVerdict     string        `json:"originalVerdict,omitempty"`
+	ReplayVerdict       string        `json:"replayVerdict,omitempty"`
+	OriginalStrategy    string        `json:"originalStrategy,omitempty"`
+	ReplayStrategy      string        `json:"replayStrategy,omitempty"`
+	Rankings            []RankingDiff `json:"rankings"`
+	RankCorrelation     *float64      `json:"rankCorrelation,omitempty"`
+	OriginalUsage       *TokenUsage   `json:"originalUsage,omitempty"`
+	ReplayUsage         *TokenUsage   `json:"replayUsage,omitempty"`
+	OriginalPickCorrect *bool         `json:"originalPickCorrect,omitempty"`
+	ReplayPickCorrect   *bool         `json:"replayPickCorrect,omitempty"`
+}
+
+// One agent's position before and after; a rank of 0 means it was not ranked on that side
+type RankingDiff struct {
+	Agent         string  `json:"agent"`
+	OriginalRank  int     `json:"originalRank"`
+	ReplayRank    int     `json:"replayRank"`
+	OriginalScore float64 `json:"originalScore"`
+	ReplayScore   float64 `json:"replayScore"`
+	RankChange    int     `json:"rankChange"`
+}
+
+// Merge the overrides into the stored request and check the result is still a valid
+// replay of the same query
+func replayRequest(record *QueryRecord, replay ReplayRequest) (QueryRequest, string, error) {
+	if replay.Mode != ReplayGeneration && replay.Mode != ReplayEvaluation {
+		return QueryRequest{}, "Invalid replay mode", fmt.Errorf("mode must be %q or %q", ReplayGeneration, ReplayEvaluation)
+	}
+	if record.Response == nil || len(record.Response.Results) == 0 {
+		return QueryRequest{}, "Nothing to replay", fmt.Errorf("query %q has no stored responses", record.QueryId)
+	}
+
+	req := record.Request
+	if len(replay.Overrides) > 0 {
+		var overrides map[string]json.RawMessage
+		if err := json.Unmarshal(replay.Overrides, &overrides); err != nil {
+			return req, "Invalid overrides", err
+		}
+		if _, ok := overrides["query"]; ok {
+			return req, "Invalid overrides", fmt.Errorf("the query itself cannot be changed")
+		}
+		if _, ok := overrides["agents"]; ok && replay.Mode == ReplayEvaluation {
+			return req, "Invalid overrides", fmt.Errorf("agents can only be changed in %s mode", ReplayGeneration)
+		}
+
+		// Replace whole top-level fields, working on a copy so the stored request is untouched
+		stored, _ := json.Marshal(record.Request)
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(stored, &fields); err != nil {
+			return req, "Invalid stored request", err
+		}
+		for name, value := range overrides {
+			fields[name] = value
+		}
+		merged, _ := json.Marshal(fields)
+		req = QueryRequest{}
+		if err := json.Unmarshal(merged, &req); err != nil {
+			return req, "Invalid overrides", err
+		}
+	}
+	if label, err := validateQueryRequest(req); err != nil {
+		return req, label, err
+	}
+	return req, "", nil
+}
+
+// Re-run the stored query: the whole pipeline in generation mode, or only the
+// evaluation over the stored responses in evaluation mode
+func runReplay(ctx context.Context, record *QueryRecord, mode string, req QueryRequest) *ReplayResult {
+	var response *QueryResponse
+	if mode == ReplayGeneration {
+		response = processQuery(ctx, req)
+	} else {
+		ctx, usage := withUsageTracking(ctx)
+		response = evaluateRound(ctx, req, req.Agents, generatedResults(record.Response.Results))
+		response.Usage = usage.total()
+	}
+	response.QueryId = record.QueryId
+
+	return &ReplayResult{
+		QueryId:  record.QueryId,
+		Mode:     mode,
+		Request:  req,
+		Response: response,
+		Diff:     diffResponses(record, req, response),
+	}
+}
+
+// Stored results stripped of everything evaluation adds, as they were when generation finished
+func generatedResults(results []AIResult) []AIResult {
+	generated := make([]AIResult, len(results))
+	for i, result := range results {
+		generated[i] = AIResult{
+			Model:             result.Model,
+			Output:            result.Output,
+			Error:             result.Error,
+			ProcessingTime:    result.ProcessingTime,
+			Timestamp:         result.Timestamp,
+			Confidence:        result.Confidence,
+			WorkerParams:      result.WorkerParams,
+			ConfidenceMethod:  result.ConfidenceMethod,
+			ConfidenceDetails: result.ConfidenceDetails,
+			Usage:             result.Usage,
+		}
+	}
+	return generated
+}
+
+func diffResponses(original *QueryRecord, replayReq QueryRequest, replayed *QueryResponse) *ReplayDiff {
+	originalNames := resultAgentNames(original.Request, original.Response)
+	replayNames := resultAgentNames(replayReq, replayed)
+
+	diff := &ReplayDiff{
+		Rankings:      make([]RankingDiff, 0),
+		OriginalUsage: original.Response.Usage,
+		ReplayUsage:   replayed.Usage,
+	}
+	if original.Response.Grading != nil {
+		diff.OriginalPickCorrect = original.Response.Grading.MasterPickCorrect
+	}
+	if replayed.Grading != nil {
+		diff.ReplayPickCorrect = replayed.Grading.MasterPickCorrect
+	}
+
+	originalOrder := describeEvaluation(original.Response.MasterEvaluation, originalNames, &diff.OriginalWinner, &diff.OriginalVerdict, &diff.OriginalStrategy)
+	replayOrder := describeEvaluation(replayed.MasterEvaluation, replayNames, &diff.ReplayWinner, &diff.ReplayVerdict, &diff.ReplayStrategy)
+	diff.WinnerChanged = diff.OriginalWinner != diff.ReplayWinner
+
+	// Put both orderings in terms of a shared numbering so they can be correlated
+	ids := make(map[string]int)
+	numbered := func(order []rankedAgent) []int {
+		numbers := make([]int, len(order))
+		for i, entry := range order {
+			if _, ok := ids[entry.name]; !ok {
+				ids[entry.name] = len(ids)
+			}
+			numbers[i] = ids[entry.name]
+		}
+		return numbers
+	}
+	if rho, ok := spearmanCorrelation(numbered(originalOrder), numbered(replayOrder)); ok {
+		diff.RankCorrelation = &rho
+	}
+
+	rows := make(map[string]*RankingDiff)
+	row := func(name string) *RankingDiff {
+		if rows[name] == nil {
+			rows[name] = &RankingDiff{Agent: name}
+		}
+		return rows[name]
+	}
+	for rank, entry := range originalOrder {
+		row(entry.name).OriginalRank = rank + 1
+		row(entry.name).OriginalScore = entry.score
+	}
+	for rank, entry := range replayOrder {
+		row(entry.name).ReplayRank = rank + 1
+		row(entry.name).ReplayScore = entry.score
+	}
+	for _, r := range rows {
+		if r.OriginalRank > 0 && r.ReplayRank > 0 {
+			r.RankChange = r.OriginalRank - r.ReplayRank
+		}
+		diff.Rankings = append(diff.Rankings, *r)
+	}
+	sort.Slice(diff.Rankings, func(i, j int) bool {
+		a, b := diff.Rankings[i], diff.Rankings[j]
+		if (a.ReplayRank == 0) != (b.ReplayRank == 0) {
+			return b.ReplayRank == 0
+		}
+		if a.ReplayRank != b.ReplayRank {
+			return a.ReplayRank < b.ReplayRank
+		}
+		return a.Agent < b.Agent
+	})
+	return diff
+}
+
+type rankedAgent struct {
+	name  string
+	score float64
+}
+
+// Fill in the winner, verdict and strategy, and return the ranking best first
+func describeEvaluation(evaluation *MasterEvaluation, names []string, winner, verdict, strategy *string) []rankedAgent {
+	if evaluation == nil {
+		return nil
+	}
+	if evaluation.BestResponseIndex >= 0 && evaluation.BestResponseIndex < len(names) {
+		*winner = names[evaluation.BestResponseIndex]
+	}
+	*verdict = evaluation.Verdict
+	*strategy = evaluation.Strategy
+
+	order := make([]rankedAgent, 0, len(evaluation.Rankings))
+	for _, ranking := range evaluation.Rankings {
+		if ranking.Index >= 0 && ranking.Index < len(names) {
+			order = append(order, rankedAgent{name: names[ranking.Index], score: ranking.Score})
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].score > order[j].score })
+	return order
+}
+
+// Agent name behind each result, in result order
+func resultAgentNames(req QueryRequest, response *QueryResponse) []string {
+	identities := agentIdentities(&QueryRecord{Request: req, Response: response})
+	names := make([]string, len(identities))
+	for i, identity := range identities {
+		names[i] = identity.Name
+	}
+	return names
+}
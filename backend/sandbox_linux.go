@@ -0,0 +1,290 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Exit status of the init process when the sandbox itself could not be set up
+const sandboxSetupFailed = 125
+
+// Host directories mounted read-only so interpreters and the Go toolchain can run.
+// Nothing else of the host filesystem is visible inside the sandbox.
+var sandboxSystemPaths = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/etc/alternatives"}
+
+// Device files available inside the sandbox
+var sandboxDevices = []string{"/dev/null", "/dev/zero", "/dev/random", "/dev/urandom"}
+
+// Start the binary itself as the sandbox's init process in fresh user, mount,
+// PID, network, IPC and UTS namespaces. It builds an empty read-only root,
+// applies the resource limits, drops every capability and execs the command.
+func newSandboxCommand(ctx context.Context, spec sandboxSpec) (*exec.Cmd, error) {
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sandbox specification: %v", err)
+	}
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", sandboxInitCommand, string(encoded))
+	cmd.Env = spec.Env
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	// Kill the whole process group, not just the init process, and stop waiting
+	// for output pipes held open by anything that escaped it
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = SandboxWaitDelay
+	return cmd, nil
+}
+
+// Entry point of the init process; only returns if the command could not be executed
+func runSandboxInit(args []string) int {
+	// Capabilities are per thread, so everything up to exec stays on this one
+	runtime.LockOSThread()
+
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "sandbox: missing specification")
+		return sandboxSetupFailed
+	}
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(args[0]), &spec); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: invalid specification: %v\n", err)
+		return sandboxSetupFailed
+	}
+	if len(spec.Command) == 0 {
+		fmt.Fprintln(os.Stderr, "sandbox: empty command")
+		return sandboxSetupFailed
+	}
+
+	if err := buildSandboxRoot(spec); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return sandboxSetupFailed
+	}
+	if err := applySandboxLimits(spec.Limits); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return sandboxSetupFailed
+	}
+
+	path, err := exec.LookPath(spec.Command[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return sandboxSetupFailed
+	}
+	if err := dropCapabilities(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return sandboxSetupFailed
+	}
+
+	err = unix.Exec(path, spec.Command, spec.Env)
+	fmt.Fprintf(os.Stderr, "sandbox: exec %s: %v\n", spec.Command[0], err)
+	return sandboxSetupFailed
+}
+
+// Mount a tmpfs root holding only the system paths (read-only), a few devices,
+// the working directory, an optional build cache and a private /tmp, then make
+// it the root of the mount namespace and leave the host filesystem behind
+func buildSandboxRoot(spec sandboxSpec) error {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %v", err)
+	}
+
+	root := spec.Root
+	if err := unix.Mount("tmpfs", root, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "size=1m,mode=0755"); err != nil {
+		return fmt.Errorf("failed to mount sandbox root: %v", err)
+	}
+
+	for _, path := range append(sandboxSystemPaths, spec.ReadOnly...) {
+		if err := bindIntoSandbox(path, filepath.Join(root, path), true); err != nil {
+			return err
+		}
+	}
+	for _, path := range sandboxDevices {
+		if err := bindIntoSandbox(path, filepath.Join(root, path), false); err != nil {
+			return err
+		}
+	}
+	if err := bindIntoSandbox(spec.WorkDir, filepath.Join(root, sandboxWorkDir), false); err != nil {
+		return err
+	}
+	if spec.CacheDir != "" {
+		if err := bindIntoSandbox(spec.CacheDir, filepath.Join(root, sandboxCacheDir), false); err != nil {
+			return err
+		}
+	}
+
+	tmp := filepath.Join(root, "tmp")
+	if err := os.MkdirAll(tmp, 0777); err != nil {
+		return fmt.Errorf("failed to create /tmp: %v", err)
+	}
+	if err := unix.Mount("tmpfs", tmp, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "size=64m,mode=1777"); err != nil {
+		return fmt.Errorf("failed to mount /tmp: %v", err)
+	}
+
+	// Nothing may be added to the root itself once it is populated
+	if err := unix.Mount("", root, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("failed to make sandbox root read-only: %v", err)
+	}
+
+	// Swap roots and detach the old one so no host path stays reachable
+	if err := unix.Chdir(root); err != nil {
+		return fmt.Errorf("failed to enter sandbox root: %v", err)
+	}
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("failed to pivot into sandbox root: %v", err)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach host root: %v", err)
+	}
+	if err := unix.Chdir(spec.Dir); err != nil {
+		return fmt.Errorf("failed to enter %s: %v", spec.Dir, err)
+	}
+	return nil
+}
+
+// Bind a host path into the sandbox root. Symlinks are recreated rather than
+// followed, and paths missing on the host are skipped.
+func bindIntoSandbox(source, target string, readOnly bool) error {
+	info, err := os.Lstat(source)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", target, err)
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	case info.IsDir():
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", target, err)
+		}
+	default:
+		if err := os.WriteFile(target, nil, 0644); err != nil {
+			return fmt.Errorf("failed to create %s: %v", target, err)
+		}
+	}
+
+	if err := unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to mount %s: %v", source, err)
+	}
+	if !readOnly {
+		return nil
+	}
+
+	// A read-only remount has to keep the flags the host mount is locked with
+	var stat unix.Statfs_t
+	if err := unix.Statfs(target, &stat); err != nil {
+		return fmt.Errorf("failed to inspect %s: %v", source, err)
+	}
+	if err := unix.Mount("", target, "", readOnlyRemountFlags(stat.Flags), ""); err != nil {
+		return fmt.Errorf("failed to make %s read-only: %v", source, err)
+	}
+	return nil
+}
+
+// statfs reports mount options with ST_* bits; all but relatime match the MS_* flags
+const stRelatime = 0x1000
+
+func readOnlyRemountFlags(statFlags int64) uintptr {
+	flags := uintptr(unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY)
+	flags |= uintptr(statFlags) & (unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_NOATIME | unix.MS_NODIRATIME)
+	if statFlags&stRelatime != 0 {
+		flags |= unix.MS_RELATIME
+	}
+	return flags
+}
+
+func applySandboxLimits(limits sandboxLimits) error {
+	rlimits := []struct {
+		name     string
+		resource int
+		value    uint64
+	}{
+		{"CPU time", unix.RLIMIT_CPU, uint64(limits.CPUSeconds)},
+		{"data size", unix.RLIMIT_DATA, uint64(limits.MemoryKB) * 1024},
+		{"file size", unix.RLIMIT_FSIZE, uint64(limits.FileKB) * 1024},
+		{"process count", unix.RLIMIT_NPROC, uint64(limits.Processes)},
+	}
+	for _, limit := range rlimits {
+		if err := unix.Setrlimit(limit.resource, &unix.Rlimit{Cur: limit.value, Max: limit.value}); err != nil {
+			return fmt.Errorf("failed to limit %s: %v", limit.name, err)
+		}
+	}
+	return nil
+}
+
+// The init process is root inside its user namespace; the command must not keep
+// any capability that could undo the read-only mounts
+func dropCapabilities() error {
+	for capability := 0; ; capability++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(capability), 0, 0, 0)
+		if err == unix.EINVAL {
+			break // Past the last capability the kernel knows
+		}
+		if err != nil {
+			return fmt.Errorf("failed to drop capability %d: %v", capability, err)
+		}
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %v", err)
+	}
+
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{}
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("failed to clear capabilities: %v", err)
+	}
+	return nil
+}
+
+// Run a trivial command once to find out whether this host allows unprivileged
+// user namespaces; the result is cached for the life of the process
+func probeSandbox() error {
+	workDir, err := os.MkdirTemp("", "hivemind-probe-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExecutionLimits.Timeout)
+	defer cancel()
+
+	_, stderr, err := runSandboxed(ctx, workDir, ".", []string{"true"}, "", ExecutionLimits)
+	if err != nil {
+		if message := strings.TrimSpace(stderr); message != "" {
+			return fmt.Errorf("%v: %s", err, message)
+		}
+		return err
+	}
+	return nil
+}
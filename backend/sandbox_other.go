@@ -0,0 +1,27 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Isolation relies on Linux namespaces, so execution is refused elsewhere
+var errSandboxUnsupported = errors.New("sandboxed execution requires Linux namespaces")
+
+func newSandboxCommand(ctx context.Context, spec sandboxSpec) (*exec.Cmd, error) {
+	return nil, errSandboxUnsupported
+}
+
+func runSandboxInit(args []string) int {
+	fmt.Fprintln(os.Stderr, "sandbox:", errSandboxUnsupported)
+	return 125
+}
+
+func probeSandbox() error {
+	return errSandboxUnsupported
+}
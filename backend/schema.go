@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// Validation for the subset of JSON Schema that answers are usually checked
+// against: type, enum/const, object properties, arrays, numeric and string
+// bounds, pattern, and allOf/anyOf/oneOf/not composition.
+
+// Parse a user-supplied schema, rejecting anything that is not a JSON object
+func parseJSONSchema(raw json.RawMessage) (map[string]interface{}, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, fmt.Errorf("schema must be a JSON object: %v", err)
+	}
+	if err := checkSchemaPatterns(schema, "$"); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// Compile every pattern in the schema and its subschemas, so a bad one is rejected
+// up front instead of being skipped during validation
+func checkSchemaPatterns(schema map[string]interface{}, path string) error {
+	if pattern, ok := schema["pattern"].(string); ok {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid pattern %q at %s: %v", pattern, path, err)
+		}
+	}
+
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if sub, ok := properties[name].(map[string]interface{}); ok {
+				if err := checkSchemaPatterns(sub, path+".properties."+name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, keyword := range []string{"additionalProperties", "items", "not"} {
+		if sub, ok := schema[keyword].(map[string]interface{}); ok {
+			if err := checkSchemaPatterns(sub, path+"."+keyword); err != nil {
+				return err
+			}
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, _ := schema[keyword].([]interface{})
+		for i, entry := range subs {
+			if sub, ok := entry.(map[string]interface{}); ok {
+				if err := checkSchemaPatterns(sub, fmt.Sprintf("%s.%s[%d]", path, keyword, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Return every violation of the schema found in value, each prefixed by its JSON path
+func validateJSONSchema(value interface{}, schema map[string]interface{}, path string) []string {
+	violations := make([]string, 0)
+	fail := func(format string, args ...interface{}) {
+		violations = append(violations, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if types, ok := schema["type"]; ok && !matchesSchemaType(value, types) {
+		fail("expected type %v, got %s", types, jsonTypeName(value))
+		return violations
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range enum {
+			if reflect.DeepEqual(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value is not one of the allowed enum values")
+		}
+	}
+
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		fail("value does not equal const %v", constant)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		violations = append(violations, validateSchemaObject(v, schema, path)...)
+	case []interface{}:
+		violations = append(violations, validateSchemaArray(v, schema, path)...)
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if minLength, ok := schema["minLength"].(float64); ok && length < minLength {
+			fail("string shorter than minLength %v", minLength)
+		}
+		if maxLength, ok := schema["maxLength"].(float64); ok && length > maxLength {
+			fail("string longer than maxLength %v", maxLength)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				fail("string does not match pattern %q", pattern)
+			}
+		}
+	case float64:
+		if minimum, ok := schema["minimum"].(float64); ok && v < minimum {
+			fail("%v is less than minimum %v", v, minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && v > maximum {
+			fail("%v is greater than maximum %v", v, maximum)
+		}
+		if exclusive, ok := schema["exclusiveMinimum"].(float64); ok && v <= exclusive {
+			fail("%v is not greater than exclusiveMinimum %v", v, exclusive)
+		}
+		if exclusive, ok := schema["exclusiveMaximum"].(float64); ok && v >= exclusive {
+			fail("%v is not less than exclusiveMaximum %v", v, exclusive)
+		}
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if subSchema, ok := sub.(map[string]interface{}); ok {
+				violations = append(violations, validateJSONSchema(value, subSchema, path)...)
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && countMatchingSchemas(value, anyOf, path) == 0 {
+		fail("value does not match any schema in anyOf")
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		if matches := countMatchingSchemas(value, oneOf, path); matches != 1 {
+			fail("value matches %d schemas in oneOf, expected exactly 1", matches)
+		}
+	}
+	if not, ok := schema["not"].(map[string]interface{}); ok && len(validateJSONSchema(value, not, path)) == 0 {
+		fail("value must not match the schema in not")
+	}
+
+	return violations
+}
+
+func validateSchemaObject(object map[string]interface{}, schema map[string]interface{}, path string) []string {
+	violations := make([]string, 0)
+
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, present := object[key]; !present {
+					violations = append(violations, fmt.Sprintf("%s: missing required property %q", path, key))
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	// Visit keys in order so diagnostics are stable
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPath := path + "." + key
+		if propSchema, ok := properties[key].(map[string]interface{}); ok {
+			violations = append(violations, validateJSONSchema(object[key], propSchema, childPath)...)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				violations = append(violations, fmt.Sprintf("%s: additional property not allowed", childPath))
+			}
+		case map[string]interface{}:
+			violations = append(violations, validateJSONSchema(object[key], additional, childPath)...)
+		}
+	}
+
+	return violations
+}
+
+func validateSchemaArray(array []interface{}, schema map[string]interface{}, path string) []string {
+	violations := make([]string, 0)
+
+	if minItems, ok := schema["minItems"].(float64); ok && float64(len(array)) < minItems {
+		violations = append(violations, fmt.Sprintf("%s: array has fewer than minItems %v", path, minItems))
+	}
+	if maxItems, ok := schema["maxItems"].(float64); ok && float64(len(array)) > maxItems {
+		violations = append(violations, fmt.Sprintf("%s: array has more than maxItems %v", path, maxItems))
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range array {
+			violations = append(violations, validateJSONSchema(item, items, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	if unique, ok := schema["uniqueItems"].(bool); ok && unique {
+		for i := 0; i < len(array); i++ {
+			for j := i + 1; j < len(array); j++ {
+				if reflect.DeepEqual(array[i], array[j]) {
+					violations = append(violations, fmt.Sprintf("%s: items %d and %d are not unique", path, i, j))
+				}
+			}
+		}
+	}
+
+	return violations
+}
+
+func countMatchingSchemas(value interface{}, schemas []interface{}, path string) int {
+	matches := 0
+	for _, sub := range schemas {
+		if subSchema, ok := sub.(map[string]interface{}); ok && len(validateJSONSchema(value, subSchema, path)) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+func matchesSchemaType(value interface{}, types interface{}) bool {
+	switch t := types.(type) {
+	case string:
+		return matchesSingleType(value, t)
+	case []interface{}:
+		for _, option := range t {
+			if name, ok := option.(string); ok && matchesSingleType(value, name) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesSingleType(value interface{}, name string) bool {
+	switch name {
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonTypeName(value) == name
+	}
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
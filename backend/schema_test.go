@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "pattern": "^[A-Z]"},
+			"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+			"tags": {"type": "array", "maxItems": 2, "uniqueItems": true, "items": {"enum": ["a", "b", "c"]}},
+			"id": {"oneOf": [{"type": "string"}, {"type": "integer"}]},
+			"note": {"type": ["string", "null"], "not": {"const": "secret"}}
+		}
+	}`
+
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"valid", `{"name": "Ada", "age": 36, "tags": ["a", "b"], "id": 7, "note": null}`, []string{}},
+		{"wrong root type", `[1, 2]`, []string{"$: expected type object, got array"}},
+		{"missing required", `{"name": "Ada"}`, []string{`$: missing required property "age"`}},
+		{"additional property", `{"name": "Ada", "age": 1, "extra": true}`, []string{"$.extra: additional property not allowed"}},
+		{"integer with fraction", `{"name": "Ada", "age": 1.5}`, []string{"$.age: expected type integer, got number"}},
+		{"numeric bounds", `{"name": "Ada", "age": 150}`, []string{"$.age: 150 is not less than exclusiveMaximum 150"}},
+		{"string pattern", `{"name": "ada", "age": 1}`, []string{`$.name: string does not match pattern "^[A-Z]"`}},
+		{"array items and uniqueness", `{"name": "Ada", "age": 1, "tags": ["a", "a", "z"]}`, []string{
+			"$.tags: array has more than maxItems 2",
+			"$.tags[2]: value is not one of the allowed enum values",
+			"$.tags: items 0 and 1 are not unique",
+		}},
+		{"oneOf matches none", `{"name": "Ada", "age": 1, "id": true}`, []string{"$.id: value matches 0 schemas in oneOf, expected exactly 1"}},
+		{"not", `{"name": "Ada", "age": 1, "note": "secret"}`, []string{"$.note: value must not match the schema in not"}},
+	}
+
+	parsed, err := parseJSONSchema(json.RawMessage(schema))
+	if err != nil {
+		t.Fatalf("parseJSONSchema: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatalf("bad test value: %v", err)
+			}
+			got := validateJSONSchema(value, parsed, "$")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr bool
+	}{
+		{"empty", ``, false},
+		{"object", `{"type": "string"}`, false},
+		{"not an object", `["string"]`, true},
+		{"invalid pattern", `{"pattern": "("}`, true},
+		{"invalid nested property pattern", `{"properties": {"x": {"pattern": "("}}}`, true},
+		{"invalid pattern in items", `{"items": {"pattern": "[a-"}}`, true},
+		{"invalid pattern in composition", `{"anyOf": [{"type": "string"}, {"not": {"pattern": "(?<"}}]}`, true},
+		{"valid nested patterns", `{"properties": {"x": {"pattern": "^a+$"}}, "oneOf": [{"pattern": "b"}]}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseJSONSchema(json.RawMessage(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A named factor in the heuristic fallback evaluator, returning a 0-1 score
+type Scorer interface {
+	Name() string
+	Score(response AIResult, index int, sc *ScoringContext) float64
+}
+
+// Everything scorers may need beyond the response itself; indices refer to Responses
+type ScoringContext struct {
+	Responses  []AIResult
+	Similarity *ResponseSimilarity
+	Weights    map[string]float64
+}
+
+// One scorer's contribution to a ranking score
+type ScoreComponent struct {
+	Scorer       string  `json:"scorer"`
+	Score        float64 `json:"score"`
+	Weight       float64 `json:"weight"`
+	Contribution float64 `json:"contribution"`
+}
+
+// Adapter so plain functions can be registered as scorers
+type scorerFunc struct {
+	name string
+	fn   func(response AIResult, index int, sc *ScoringContext) float64
+}
+
+func (s scorerFunc) Name() string { return s.name }
+
+func (s scorerFunc) Score(response AIResult, index int, sc *ScoringContext) float64 {
+	return s.fn(response, index, sc)
+}
+
+var scorerRegistry = map[string]Scorer{}
+
+func registerScorer(scorer Scorer) {
+	scorerRegistry[scorer.Name()] = scorer
+}
+
+// Weights used when neither the request nor HIVEMIND_SCORER_WEIGHTS choose scorers.
+// Weights are normalized, and "tests" scores 1.0 when a query has no test cases.
+var defaultScorerWeights = map[string]float64{
+	"confidence":      0.25,
+	"length":          0.15,
+	"content_quality": 0.20,
+	"efficiency":      0.05,
+	"parameters":      0.05,
+	"consensus":       0.10,
+	"uniqueness":      0.10,
+	"verification":    0.10,
+	"tests":           0.15,
+}
+
+func init() {
+	registerScorer(scorerFunc{"confidence", func(response AIResult, index int, sc *ScoringContext) float64 {
+		return response.Confidence
+	}})
+	registerScorer(scorerFunc{"length", func(response AIResult, index int, sc *ScoringContext) float64 {
+		return calculateLengthScore(strings.TrimSpace(response.Output))
+	}})
+	registerScorer(scorerFunc{"content_quality", func(response AIResult, index int, sc *ScoringContext) float64 {
+		return calculateContentQuality(strings.TrimSpace(response.Output))
+	}})
+	registerScorer(scorerFunc{"efficiency", func(response AIResult, index int, sc *ScoringContext) float64 {
+		return calculateEfficiencyScore(response.ProcessingTime)
+	}})
+	registerScorer(scorerFunc{"parameters", func(response AIResult, index int, sc *ScoringContext) float64 {
+		return calculateParameterScore(response.WorkerParams)
+	}})
+	registerScorer(scorerFunc{"consensus", func(response AIResult, index int, sc *ScoringContext) float64 {
+		return calculateConsensusScore(index, sc.Similarity)
+	}})
+	registerScorer(scorerFunc{"uniqueness", func(response AIResult, index int, sc *ScoringContext) float64 {
+		return calculateUniquenessScore(index, sc.Similarity)
+	}})
+	registerScorer(scorerFunc{"verification", func(response AIResult, index int, sc *ScoringContext) float64 {
+		return calculateVerificationScore(response.Verification)
+	}})
+	registerScorer(scorerFunc{"tests", func(response AIResult, index int, sc *ScoringContext) float64 {
+		return calculateTestScore(response.Execution)
+	}})
+	registerScorer(scorerFunc{"claims", func(response AIResult, index int, sc *ScoringContext) float64 {
+		return calculateClaimScore(response.Claims)
+	}})
+}
+
+// Check that every named scorer exists and no weight is negative
+func validateScorerWeights(weights map[string]float64) error {
+	total := 0.0
+	for name, weight := range weights {
+		if _, ok := scorerRegistry[name]; !ok {
+			return fmt.Errorf("unknown scorer %q (available: %s)", name, strings.Join(registeredScorerNames(), ", "))
+		}
+		if weight < 0 {
+			return fmt.Errorf("scorer %q has negative weight %.2f", name, weight)
+		}
+		total += weight
+	}
+	if len(weights) > 0 && total == 0 {
+		return fmt.Errorf("scorer weights must not all be zero")
+	}
+	return nil
+}
+
+// Parse "name=weight,name=weight" as used by HIVEMIND_SCORER_WEIGHTS
+func parseScorerWeights(spec string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid scorer weight %q, expected name=weight", part)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight for scorer %q: %v", name, err)
+		}
+		weights[strings.TrimSpace(name)] = weight
+	}
+	if err := validateScorerWeights(weights); err != nil {
+		return nil, err
+	}
+	return weights, nil
+}
+
+func registeredScorerNames() []string {
+	names := make([]string, 0, len(scorerRegistry))
+	for name := range scorerRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Active weights, normalized to sum to 1 so totals stay in the 0-1 range
+func (sc *ScoringContext) normalizedWeights() map[string]float64 {
+	weights := sc.Weights
+	if len(weights) == 0 {
+		weights = defaultScorerWeights
+	}
+
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+
+	normalized := make(map[string]float64, len(weights))
+	for name, weight := range weights {
+		if total > 0 {
+			normalized[name] = weight / total
+		}
+	}
+	return normalized
+}
+
+// Restrict the context to a subset of responses, re-indexed in subset order
+func (sc *ScoringContext) subset(indices []int) *ScoringContext {
+	sub := &ScoringContext{
+		Responses: make([]AIResult, len(indices)),
+		Weights:   sc.Weights,
+	}
+	for a, i := range indices {
+		sub.Responses[a] = sc.Responses[i]
+	}
+	if sc.Similarity != nil {
+		sub.Similarity = sc.Similarity.subset(indices)
+	}
+	return sub
+}
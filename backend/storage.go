@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Storage backends, chosen with HIVEMIND_STORAGE
+const (
+	StorageJSONL    = "jsonl"
+	StorageSupabase = "supabase"
+	StorageNone     = "none"
+)
+
+// Default directory for the JSONL store, overridable with HIVEMIND_DATA_DIR
+const DefaultDataDir = "data"
+
+// Time allowed for a storage call once the query itself has finished
+const StorageTimeout = 10 * time.Second
+
+var errNotFound = errors.New("not found")
+
+// Everything persisted about one query: the request as received, the response as
+// returned (every AIResult with its WorkerParams, and the MasterEvaluation), and
+// every prompt sent to the model while producing it
+type QueryRecord struct {
+	QueryId   string         `json:"queryId"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Request   QueryRequest   `json:"request"`
+	Response  *QueryResponse `json:"response"`
+	Prompts   []PromptRecord `json:"prompts"`
+}
+
+// One model call: the exact prompt, the parameters it was sent with, and what came back
+type PromptRecord struct {
+	WorkerID       string       `json:"workerId"`
+	Prompt         string       `json:"prompt"`
+	Params         WorkerParams `json:"params"`
+	Output         string       `json:"output,omitempty"`
+	Error          string       `json:"error,omitempty"`
+	ProcessingTime int64        `json:"processingTime"`
+	Timestamp      time.Time    `json:"timestamp"`
+}
+
+type Store interface {
+	SaveQuery(ctx context.Context, record *QueryRecord) error
+	GetQuery(ctx context.Context, queryId string) (*QueryRecord, error)
+	ListQueries(ctx context.Context, filter QueryFilter) (*QueryPage, error)
+	// ScanQueries calls fn with every stored query, oldest first, stopping at the first error
+	ScanQueries(ctx context.Context, fn func(record *QueryRecord) error) error
+	SaveVote(ctx context.Context, vote CalibrationVote) error
+	ListVotes(ctx context.Context) ([]CalibrationVote, error)
+	SaveFeedback(ctx context.Context, feedback QueryFeedback) error
+	// ListFeedback returns feedback for one query, or for every query when queryId is empty
+	ListFeedback(ctx context.Context, queryId string) ([]QueryFeedback, error)
+}
+
+var store Store = noopStore{}
+
+// Pick the backend from the environment: Supabase when SUPABASE_URL is set, JSONL files otherwise
+func openStore() (Store, error) {
+	backend := os.Getenv("HIVEMIND_STORAGE")
+	if backend == "" {
+		backend = StorageJSONL
+		if os.Getenv("SUPABASE_URL") != "" {
+			backend = StorageSupabase
+		}
+	}
+
+	switch backend {
+	case StorageJSONL:
+		dir := os.Getenv("HIVEMIND_DATA_DIR")
+		if dir == "" {
+			dir = DefaultDataDir
+		}
+		return openJSONLStore(dir)
+	case StorageSupabase:
+		return newSupabaseStore(os.Getenv("SUPABASE_URL"), os.Getenv("SUPABASE_SERVICE_KEY"))
+	case StorageNone:
+		return noopStore{}, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q (use %s, %s or %s)", backend, StorageJSONL, StorageSupabase, StorageNone)
+}
+
+// Store used when persistence is disabled
+type noopStore struct{}
+
+func (noopStore) SaveQuery(ctx context.Context, record *QueryRecord) error { return nil }
+
+func (noopStore) GetQuery(ctx context.Context, queryId string) (*QueryRecord, error) {
+	return nil, errNotFound
+}
+
+func (noopStore) ListQueries(ctx context.Context, filter QueryFilter) (*QueryPage, error) {
+	return paginateSummaries(nil, filter), nil
+}
+
+func (noopStore) ScanQueries(ctx context.Context, fn func(record *QueryRecord) error) error {
+	return nil
+}
+
+func (noopStore) SaveVote(ctx context.Context, vote CalibrationVote) error { return nil }
+
+func (noopStore) ListVotes(ctx context.Context) ([]CalibrationVote, error) { return nil, nil }
+
+func (noopStore) SaveFeedback(ctx context.Context, feedback QueryFeedback) error { return nil }
+
+func (noopStore) ListFeedback(ctx context.Context, queryId string) ([]QueryFeedback, error) {
+	return nil, nil
+}
+
+type promptContextKey struct{}
+
+// Prompts sent with a context, in the order the calls finished
+type promptRecorder struct {
+	mu      sync.Mutex
+	prompts []PromptRecord
+}
+
+func withPromptRecording(ctx context.Context) (context.Context, *promptRecorder) {
+	recorder := &promptRecorder{prompts: make([]PromptRecord, 0)}
+	return context.WithValue(ctx, promptContextKey{}, recorder), recorder
+}
+
+func recordPrompt(ctx context.Context, prompt string, params WorkerParams, result AIResult) {
+	recorder, ok := ctx.Value(promptContextKey{}).(*promptRecorder)
+	if !ok {
+		return
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.prompts = append(recorder.prompts, PromptRecord{
+		WorkerID:       params.WorkerID,
+		Prompt:         prompt,
+		Params:         params,
+		Output:         result.Output,
+		Error:          result.Error,
+		ProcessingTime: result.ProcessingTime,
+		Timestamp:      result.Timestamp,
+	})
+}
+
+func (r *promptRecorder) all() []PromptRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]PromptRecord{}, r.prompts...)
+}
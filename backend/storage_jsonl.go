@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Records can be large (full responses plus every prompt), so allow long lines
+const maxRecordLineBytes = 64 * 1024 * 1024
+
+// Append-only JSONL files: one QueryRecord, CalibrationVote or QueryFeedback per line. Query records
+// are looked up through an in-memory index of line offsets built when the store opens.
+type jsonlStore struct {
+	mu       sync.Mutex
+	queries  *os.File
+	votes    *os.File
+	feedback *os.File
+	index    map[string]recordLocation
+	size     int64
+}
+
+type recordLocation struct {
+	offset int64
+	length int
+}
+
+func openJSONLStore(dir string) (*jsonlStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating data directory: %v", err)
+	}
+
+	queries, err := os.OpenFile(filepath.Join(dir, "queries.jsonl"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	votes, err := os.OpenFile(filepath.Join(dir, "votes.jsonl"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		queries.Close()
+		return nil, err
+	}
+	feedback, err := os.OpenFile(filepath.Join(dir, "feedback.jsonl"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		queries.Close()
+		votes.Close()
+		return nil, err
+	}
+
+	// A write interrupted by a crash leaves a partial last line; cut it off so the next
+	// record starts on a fresh line instead of being glued onto it and lost
+	for _, file := range []*os.File{queries, votes, feedback} {
+		if err := trimPartialLine(file); err != nil {
+			queries.Close()
+			votes.Close()
+			feedback.Close()
+			return nil, fmt.Errorf("recovering %s: %v", filepath.Base(file.Name()), err)
+		}
+	}
+
+	s := &jsonlStore{queries: queries, votes: votes, feedback: feedback, index: make(map[string]recordLocation)}
+	if err := s.buildIndex(); err != nil {
+		queries.Close()
+		votes.Close()
+		feedback.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Scan the query log once, remembering where each record starts; a later record
+// with the same id replaces an earlier one
+func (s *jsonlStore) buildIndex() error {
+	reader := bufio.NewReaderSize(s.queries, 64*1024)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var header struct {
+				QueryId string `json:"queryId"`
+			}
+			if json.Unmarshal(line, &header) == nil && header.QueryId != "" {
+				s.index[header.QueryId] = recordLocation{offset: offset, length: len(line)}
+			}
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading query log: %v", err)
+		}
+	}
+	s.size = offset
+	return nil
+}
+
+// Truncate a file after its last newline, dropping any partial record that follows it
+func trimPartialLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64*1024)
+	end := info.Size()
+	for end > 0 {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+		if _, err := file.ReadAt(chunk, start); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			end = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+
+	if end == info.Size() {
+		return nil
+	}
+	return file.Truncate(end)
+}
+
+func (s *jsonlStore) SaveQuery(ctx context.Context, record *QueryRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.queries.WriteAt(line, s.size); err != nil {
+		return err
+	}
+	s.index[record.QueryId] = recordLocation{offset: s.size, length: len(line)}
+	s.size += int64(len(line))
+	return nil
+}
+
+func (s *jsonlStore) GetQuery(ctx context.Context, queryId string) (*QueryRecord, error) {
+	s.mu.Lock()
+	location, ok := s.index[queryId]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errNotFound
+	}
+
+	buf := make([]byte, location.length)
+	if _, err := s.queries.ReadAt(buf, location.offset); err != nil {
+		return nil, err
+	}
+	var record QueryRecord
+	if err := json.Unmarshal(buf, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Decode every live record and filter in memory; fine for the local store, which is
+// meant for development and modest histories
+func (s *jsonlStore) ListQueries(ctx context.Context, filter QueryFilter) (*QueryPage, error) {
+	summaries := make([]QuerySummary, 0)
+	err := s.ScanQueries(ctx, func(record *QueryRecord) error {
+		summary := summarizeRecord(record)
+		if filter.matches(summary, recordSearchText(record)) {
+			summaries = append(summaries, summary)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paginateSummaries(summaries, filter), nil
+}
+
+// Records are visited in the order they were saved, skipping ones replaced by a later save
+func (s *jsonlStore) ScanQueries(ctx context.Context, fn func(record *QueryRecord) error) error {
+	s.mu.Lock()
+	size := s.size
+	live := make(map[int64]bool, len(s.index))
+	for _, location := range s.index {
+		live[location.offset] = true
+	}
+	s.mu.Unlock()
+
+	reader := bufio.NewReaderSize(io.NewSectionReader(s.queries, 0, size), 64*1024)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && live[offset] {
+			var record QueryRecord
+			if json.Unmarshal(line, &record) == nil {
+				if err := fn(&record); err != nil {
+					return err
+				}
+			}
+		}
+		offset += int64(len(line))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading query log: %v", err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *jsonlStore) SaveVote(ctx context.Context, vote CalibrationVote) error {
+	return s.appendLine(s.votes, vote)
+}
+
+func (s *jsonlStore) ListVotes(ctx context.Context) ([]CalibrationVote, error) {
+	votes := make([]CalibrationVote, 0)
+	err := s.scanLines(s.votes, func(line []byte) {
+		var vote CalibrationVote
+		if json.Unmarshal(line, &vote) == nil {
+			votes = append(votes, vote)
+		}
+	})
+	return votes, err
+}
+
+func (s *jsonlStore) SaveFeedback(ctx context.Context, feedback QueryFeedback) error {
+	return s.appendLine(s.feedback, feedback)
+}
+
+func (s *jsonlStore) ListFeedback(ctx context.Context, queryId string) ([]QueryFeedback, error) {
+	entries := make([]QueryFeedback, 0)
+	err := s.scanLines(s.feedback, func(line []byte) {
+		var entry QueryFeedback
+		if json.Unmarshal(line, &entry) == nil && (queryId == "" || entry.QueryId == queryId) {
+			entries = append(entries, entry)
+		}
+	})
+	return entries, err
+}
+
+func (s *jsonlStore) appendLine(file *os.File, value interface{}) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Call fn with every line of an append-only file, oldest first
+func (s *jsonlStore) scanLines(file *os.File, fn func(line []byte)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordLineBytes)
+	for scanner.Scan() {
+		fn(scanner.Bytes())
+	}
+	return scanner.Err()
+}
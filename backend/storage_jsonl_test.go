@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openTestJSONLStore(t *testing.T, dir string) *jsonlStore {
+	t.Helper()
+	s, err := openJSONLStore(dir)
+	if err != nil {
+		t.Fatalf("openJSONLStore: %v", err)
+	}
+	t.Cleanup(func() {
+		s.queries.Close()
+		s.votes.Close()
+		s.feedback.Close()
+	})
+	return s
+}
+
+func testQueryRecord(queryId, query string) *QueryRecord {
+	return &QueryRecord{QueryId: queryId, Request: QueryRequest{Query: query}, Response: &QueryResponse{QueryId: queryId}}
+}
+
+func scannedQueries(t *testing.T, s *jsonlStore) []string {
+	t.Helper()
+	queries := make([]string, 0)
+	err := s.ScanQueries(context.Background(), func(record *QueryRecord) error {
+		queries = append(queries, record.QueryId+":"+record.Request.Query)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanQueries: %v", err)
+	}
+	return queries
+}
+
+func TestJSONLStoreReopenAndReplace(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := openTestJSONLStore(t, dir)
+	for _, record := range []*QueryRecord{
+		testQueryRecord("q1", "first"),
+		testQueryRecord("q2", "second"),
+		testQueryRecord("q1", "first, replaced"),
+	} {
+		if err := s.SaveQuery(ctx, record); err != nil {
+			t.Fatalf("SaveQuery: %v", err)
+		}
+	}
+	if err := s.SaveVote(ctx, CalibrationVote{HumanVote: HumanVote{QueryId: "q2", BestIndex: 1}}); err != nil {
+		t.Fatalf("SaveVote: %v", err)
+	}
+	if err := s.SaveFeedback(ctx, QueryFeedback{QueryId: "q2", BestIndex: 0}); err != nil {
+		t.Fatalf("SaveFeedback: %v", err)
+	}
+
+	// The index is rebuilt from disk, so a reopened store must agree with the first
+	reopened := openTestJSONLStore(t, dir)
+	for name, store := range map[string]*jsonlStore{"original": s, "reopened": reopened} {
+		record, err := store.GetQuery(ctx, "q1")
+		if err != nil {
+			t.Fatalf("%s GetQuery: %v", name, err)
+		}
+		if record.Request.Query != "first, replaced" {
+			t.Errorf("%s q1 = %q, want the later record", name, record.Request.Query)
+		}
+		if _, err := store.GetQuery(ctx, "missing"); !errors.Is(err, errNotFound) {
+			t.Errorf("%s missing query err = %v, want errNotFound", name, err)
+		}
+		if got, want := scannedQueries(t, store), []string{"q2:second", "q1:first, replaced"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s scan = %v, want %v", name, got, want)
+		}
+	}
+
+	votes, err := reopened.ListVotes(ctx)
+	if err != nil || len(votes) != 1 || votes[0].QueryId != "q2" {
+		t.Errorf("votes = %+v, %v; want the one saved vote", votes, err)
+	}
+	feedback, err := reopened.ListFeedback(ctx, "q2")
+	if err != nil || len(feedback) != 1 {
+		t.Errorf("feedback = %+v, %v; want the one saved entry", feedback, err)
+	}
+}
+
+func TestJSONLStoreRecoversPartialLines(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := openTestJSONLStore(t, dir)
+	if err := s.SaveQuery(ctx, testQueryRecord("q1", "first")); err != nil {
+		t.Fatalf("SaveQuery: %v", err)
+	}
+	if err := s.SaveVote(ctx, CalibrationVote{HumanVote: HumanVote{QueryId: "q1", BestIndex: 0}}); err != nil {
+		t.Fatalf("SaveVote: %v", err)
+	}
+	if err := s.SaveFeedback(ctx, QueryFeedback{QueryId: "q1", BestIndex: 0}); err != nil {
+		t.Fatalf("SaveFeedback: %v", err)
+	}
+
+	// Simulate writes cut short by a crash
+	for _, name := range []string{"queries.jsonl", "votes.jsonl", "feedback.jsonl"} {
+		file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.WriteString(`{"queryId":"q9","partial`); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+
+	recovered := openTestJSONLStore(t, dir)
+	if err := recovered.SaveQuery(ctx, testQueryRecord("q2", "second")); err != nil {
+		t.Fatalf("SaveQuery: %v", err)
+	}
+	if err := recovered.SaveVote(ctx, CalibrationVote{HumanVote: HumanVote{QueryId: "q2", BestIndex: 1}}); err != nil {
+		t.Fatalf("SaveVote: %v", err)
+	}
+	if err := recovered.SaveFeedback(ctx, QueryFeedback{QueryId: "q2", BestIndex: 1}); err != nil {
+		t.Fatalf("SaveFeedback: %v", err)
+	}
+
+	final := openTestJSONLStore(t, dir)
+	if got, want := scannedQueries(t, final), []string{"q1:first", "q2:second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("scan = %v, want %v", got, want)
+	}
+	votes, err := final.ListVotes(ctx)
+	if err != nil || len(votes) != 2 || votes[1].QueryId != "q2" {
+		t.Errorf("votes = %+v, %v; want both saved votes", votes, err)
+	}
+	feedback, err := final.ListFeedback(ctx, "")
+	if err != nil || len(feedback) != 2 || feedback[1].QueryId != "q2" {
+		t.Errorf("feedback = %+v, %v; want both saved entries", feedback, err)
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rows fetched per request when listing; PostgREST caps responses at 1000 by default
+const supabasePageSize = 1000
+
+// Full query rows are large, so scans fetch fewer at a time
+const supabaseScanPageSize = 100
+
+// Postgres through Supabase's REST (PostgREST) API; tables are created by supabase_schema.sql
+type supabaseStore struct {
+	baseURL string
+	key     string
+	client  *http.Client
+}
+
+type supabaseQueryRow struct {
+	QueryId   string          `json:"query_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Query     string          `json:"query"`
+	Request   json.RawMessage `json:"request"`
+	Response  json.RawMessage `json:"response"`
+	Prompts   json.RawMessage `json:"prompts,omitempty"`
+
+	// Denormalised from the response so GET /queries can filter without unpacking it
+	Agents      []string `json:"agents"`
+	Models      []string `json:"models"`
+	Winner      string   `json:"winner"`
+	WinnerIndex int      `json:"winner_index"`
+	HasError    bool     `json:"has_error"`
+	ResultCount int      `json:"result_count"`
+	SearchText  string   `json:"search_text,omitempty"`
+}
+
+// One AIResult per row, so results can be analysed in SQL without unpacking the response
+type supabaseResultRow struct {
+	QueryId        string        `json:"query_id"`
+	ResultIndex    int           `json:"result_index"`
+	Model          string        `json:"model"`
+	Output         string        `json:"output"`
+	Error          string        `json:"error"`
+	Confidence     float64       `json:"confidence"`
+	ProcessingTime int64         `json:"processing_time"`
+	WorkerParams   *WorkerParams `json:"worker_params"`
+	IsBest         bool          `json:"is_best"`
+}
+
+type supabaseVoteRow struct {
+	QueryId   string        `json:"query_id"`
+	BestIndex int           `json:"best_index"`
+	Rubric    string        `json:"rubric"`
+	Agrees    bool          `json:"agrees"`
+	Decision  JudgeDecision `json:"decision"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+func newSupabaseStore(baseURL, key string) (*supabaseStore, error) {
+	if baseURL == "" || key == "" {
+		return nil, fmt.Errorf("supabase storage needs SUPABASE_URL and SUPABASE_SERVICE_KEY")
+	}
+	return &supabaseStore{
+		baseURL: strings.TrimRight(baseURL, "/") + "/rest/v1/",
+		key:     key,
+		client:  &http.Client{Timeout: StorageTimeout},
+	}, nil
+}
+
+// The query row and its result rows are written by one database function, so a
+// record is saved completely or not at all, and saving an id again replaces it
+func (s *supabaseStore) SaveQuery(ctx context.Context, record *QueryRecord) error {
+	request, err := json.Marshal(record.Request)
+	if err != nil {
+		return fmt.Errorf("encoding request: %v", err)
+	}
+	response, err := json.Marshal(record.Response)
+	if err != nil {
+		return fmt.Errorf("encoding response: %v", err)
+	}
+	prompts := record.Prompts
+	if prompts == nil {
+		prompts = make([]PromptRecord, 0)
+	}
+	promptsJSON, err := json.Marshal(prompts)
+	if err != nil {
+		return fmt.Errorf("encoding prompts: %v", err)
+	}
+
+	summary := summarizeRecord(record)
+	row := supabaseQueryRow{
+		QueryId:     record.QueryId,
+		CreatedAt:   record.CreatedAt,
+		Query:       record.Request.Query,
+		Request:     request,
+		Response:    response,
+		Prompts:     promptsJSON,
+		Agents:      summary.Agents,
+		Models:      summary.Models,
+		Winner:      summary.Winner,
+		WinnerIndex: summary.WinnerIndex,
+		HasError:    summary.HasError,
+		ResultCount: summary.ResultCount,
+		SearchText:  recordSearchText(record),
+	}
+
+	best := -1
+	rows := make([]supabaseResultRow, 0)
+	if record.Response != nil {
+		if record.Response.MasterEvaluation != nil {
+			best = record.Response.MasterEvaluation.BestResponseIndex
+		}
+		for i, result := range record.Response.Results {
+			rows = append(rows, supabaseResultRow{
+				QueryId:        record.QueryId,
+				ResultIndex:    i,
+				Model:          result.Model,
+				Output:         result.Output,
+				Error:          result.Error,
+				Confidence:     result.Confidence,
+				ProcessingTime: result.ProcessingTime,
+				WorkerParams:   result.WorkerParams,
+				IsBest:         i == best,
+			})
+		}
+	}
+
+	body := map[string]interface{}{"query_row": row, "result_rows": rows}
+	return s.do(ctx, http.MethodPost, "rpc/hivemind_save_query", nil, body, nil)
+}
+
+func (s *supabaseStore) GetQuery(ctx context.Context, queryId string) (*QueryRecord, error) {
+	params := url.Values{
+		"query_id": {"eq." + queryId},
+		"select":   {"query_id,created_at,query,request,response,prompts"},
+	}
+	var rows []supabaseQueryRow
+	if err := s.do(ctx, http.MethodGet, "hivemind_queries", params, nil, &rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errNotFound
+	}
+	return rows[0].record()
+}
+
+func (row supabaseQueryRow) record() (*QueryRecord, error) {
+	record := &QueryRecord{QueryId: row.QueryId, CreatedAt: row.CreatedAt}
+	if err := json.Unmarshal(row.Request, &record.Request); err != nil {
+		return nil, fmt.Errorf("decoding stored request: %v", err)
+	}
+	if err := json.Unmarshal(row.Response, &record.Response); err != nil {
+		return nil, fmt.Errorf("decoding stored response: %v", err)
+	}
+	if len(row.Prompts) > 0 {
+		if err := json.Unmarshal(row.Prompts, &record.Prompts); err != nil {
+			return nil, fmt.Errorf("decoding stored prompts: %v", err)
+		}
+	}
+	return record, nil
+}
+
+// Filters run in Postgres; search terms become ILIKE substring matches on the query and outputs
+func (s *supabaseStore) ListQueries(ctx context.Context, filter QueryFilter) (*QueryPage, error) {
+	params := url.Values{
+		"select": {"query_id,created_at,query,agents,models,winner,winner_index,has_error,result_count"},
+		"order":  {"created_at.desc"},
+		"limit":  {fmt.Sprint(filter.Limit)},
+		"offset": {fmt.Sprint(filter.Offset)},
+	}
+	if terms := searchTerms(filter.Search); len(terms) > 0 {
+		conditions := make([]string, len(terms))
+		for i, term := range terms {
+			conditions[i] = "search_text.ilike." + postgrestQuote("*"+escapeLikePattern(term)+"*")
+		}
+		params.Set("and", "("+strings.Join(conditions, ",")+")")
+	}
+	if filter.Agent != "" {
+		params.Set("agents", "cs."+postgresArray(filter.Agent))
+	}
+	if filter.Model != "" {
+		params.Set("models", "cs."+postgresArray(filter.Model))
+	}
+	if filter.Winner != "" {
+		params.Set("winner", "eq."+filter.Winner)
+	}
+	if filter.From != nil {
+		params.Add("created_at", "gte."+filter.From.UTC().Format(time.RFC3339Nano))
+	}
+	if filter.To != nil {
+		params.Add("created_at", "lt."+filter.To.UTC().Format(time.RFC3339Nano))
+	}
+	if filter.HasError != nil {
+		params.Set("has_error", fmt.Sprintf("is.%t", *filter.HasError))
+	}
+
+	var rows []supabaseQueryRow
+	header, err := s.request(ctx, http.MethodGet, "hivemind_queries", params, nil, &rows, "count=exact")
+	if err != nil {
+		return nil, err
+	}
+
+	page := &QueryPage{Queries: make([]QuerySummary, len(rows)), Limit: filter.Limit, Offset: filter.Offset}
+	for i, row := range rows {
+		page.Queries[i] = QuerySummary{
+			QueryId:     row.QueryId,
+			CreatedAt:   row.CreatedAt,
+			Query:       row.Query,
+			Agents:      row.Agents,
+			Models:      row.Models,
+			WinnerIndex: row.WinnerIndex,
+			Winner:      row.Winner,
+			HasError:    row.HasError,
+			ResultCount: row.ResultCount,
+		}
+	}
+
+	// Content-Range looks like "0-19/57", or "*/0" when nothing matched
+	contentRange := header.Get("Content-Range")
+	if slash := strings.LastIndex(contentRange, "/"); slash >= 0 {
+		page.Total, _ = strconv.Atoi(contentRange[slash+1:])
+	}
+	return page, nil
+}
+
+// Prompts are not loaded; callers scanning history only need requests and responses
+func (s *supabaseStore) ScanQueries(ctx context.Context, fn func(record *QueryRecord) error) error {
+	for offset := 0; ; offset += supabaseScanPageSize {
+		params := url.Values{
+			"select": {"query_id,created_at,query,request,response"},
+			"order":  {"created_at.asc,query_id.asc"},
+			"limit":  {fmt.Sprint(supabaseScanPageSize)},
+			"offset": {fmt.Sprint(offset)},
+		}
+		var rows []supabaseQueryRow
+		if err := s.do(ctx, http.MethodGet, "hivemind_queries", params, nil, &rows); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			record, err := row.record()
+			if err != nil {
+				return err
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		if len(rows) < supabaseScanPageSize {
+			return nil
+		}
+	}
+}
+
+// Escape LIKE wildcards so a term matches literally. PostgREST turns "*" into "%",
+// so an asterisk in a term becomes a wildcard too.
+func escapeLikePattern(term string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+}
+
+// Double-quote a value inside a PostgREST logical expression, where commas and
+// parentheses would otherwise end it
+func postgrestQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// A one-element Postgres array literal, quoted so commas and braces in names are safe
+func postgresArray(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+	return `{"` + escaped + `"}`
+}
+
+func (s *supabaseStore) SaveVote(ctx context.Context, vote CalibrationVote) error {
+	row := supabaseVoteRow{
+		QueryId:   vote.QueryId,
+		BestIndex: vote.BestIndex,
+		Rubric:    vote.Rubric,
+		Agrees:    vote.Agrees,
+		Decision:  vote.Decision,
+		CreatedAt: vote.Timestamp,
+	}
+	return s.do(ctx, http.MethodPost, "hivemind_votes", nil, []supabaseVoteRow{row}, nil)
+}
+
+func (s *supabaseStore) ListVotes(ctx context.Context) ([]CalibrationVote, error) {
+	votes := make([]CalibrationVote, 0)
+	for offset := 0; ; offset += supabasePageSize {
+		params := url.Values{
+			"select": {"*"},
+			"order":  {"created_at.asc"},
+			"limit":  {fmt.Sprint(supabasePageSize)},
+			"offset": {fmt.Sprint(offset)},
+		}
+		var rows []supabaseVoteRow
+		if err := s.do(ctx, http.MethodGet, "hivemind_votes", params, nil, &rows); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			votes = append(votes, CalibrationVote{
+				HumanVote: HumanVote{
+					QueryId:   row.QueryId,
+					BestIndex: row.BestIndex,
+					Rubric:    row.Rubric,
+					Timestamp: row.CreatedAt,
+				},
+				Decision: row.Decision,
+				Agrees:   row.Agrees,
+			})
+		}
+		if len(rows) < supabasePageSize {
+			return votes, nil
+		}
+	}
+}
+
+type supabaseFeedbackRow struct {
+	QueryId   string             `json:"query_id"`
+	BestIndex int                `json:"best_index"`
+	Agents    []string           `json:"agents"`
+	Responses []ResponseFeedback `json:"responses"`
+	Comment   string             `json:"comment"`
+	Rubric    string             `json:"rubric"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+func (s *supabaseStore) SaveFeedback(ctx context.Context, feedback QueryFeedback) error {
+	row := supabaseFeedbackRow{
+		QueryId:   feedback.QueryId,
+		BestIndex: feedback.BestIndex,
+		Agents:    feedback.Agents,
+		Responses: feedback.Responses,
+		Comment:   feedback.Comment,
+		Rubric:    feedback.Rubric,
+		CreatedAt: feedback.Timestamp,
+	}
+	if row.Responses == nil {
+		row.Responses = make([]ResponseFeedback, 0)
+	}
+	return s.do(ctx, http.MethodPost, "hivemind_feedback", nil, []supabaseFeedbackRow{row}, nil)
+}
+
+func (s *supabaseStore) ListFeedback(ctx context.Context, queryId string) ([]QueryFeedback, error) {
+	entries := make([]QueryFeedback, 0)
+	for offset := 0; ; offset += supabasePageSize {
+		params := url.Values{
+			"select": {"*"},
+			"order":  {"created_at.asc"},
+			"limit":  {fmt.Sprint(supabasePageSize)},
+			"offset": {fmt.Sprint(offset)},
+		}
+		if queryId != "" {
+			params.Set("query_id", "eq."+queryId)
+		}
+		var rows []supabaseFeedbackRow
+		if err := s.do(ctx, http.MethodGet, "hivemind_feedback", params, nil, &rows); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			entries = append(entries, QueryFeedback{
+				QueryId:   row.QueryId,
+				BestIndex: row.BestIndex,
+				Agents:    row.Agents,
+				Responses: row.Responses,
+				Comment:   row.Comment,
+				Rubric:    row.Rubric,
+				Timestamp: row.CreatedAt,
+			})
+		}
+		if len(rows) < supabasePageSize {
+			return entries, nil
+		}
+	}
+}
+
+// Send one PostgREST request, decoding the JSON reply into out when given
+func (s *supabaseStore) do(ctx context.Context, method, table string, params url.Values, body interface{}, out interface{}) error {
+	_, err := s.request(ctx, method, table, params, body, out, "")
+	return err
+}
+
+// Like do, with an extra Prefer directive; returns the response headers
+func (s *supabaseStore) request(ctx context.Context, method, table string, params url.Values, body interface{}, out interface{}, prefer string) (http.Header, error) {
+	endpoint := s.baseURL + table
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("apikey", s.key)
+	req.Header.Set("Authorization", "Bearer "+s.key)
+	req.Header.Set("Content-Type", "application/json")
+	if method == http.MethodPost {
+		prefer = strings.TrimPrefix(prefer+",return=minimal", ",")
+	}
+	if prefer != "" {
+		req.Header.Set("Prefer", prefer)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("supabase request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading supabase response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("supabase error (status %d): %s", resp.StatusCode, string(responseBody))
+	}
+	if out != nil {
+		if err := json.Unmarshal(responseBody, out); err != nil {
+			return nil, fmt.Errorf("decoding supabase response: %v", err)
+		}
+	}
+	return resp.Header, nil
+}
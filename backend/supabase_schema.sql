@@ -0,0 +1,103 @@
+-- Tables used by the Supabase storage backend (HIVEMIND_STORAGE=supabase).
+-- Run once in the Supabase SQL editor.
+
+create table if not exists hivemind_queries (
+    query_id   text primary key,
+    created_at timestamptz not null default now(),
+    query      text not null,
+    request    jsonb not null,
+    response   jsonb not null,
+    prompts    jsonb not null default '[]'::jsonb
+);
+
+-- Summary columns used by GET /queries filters
+alter table hivemind_queries add column if not exists agents       text[] not null default '{}';
+alter table hivemind_queries add column if not exists models       text[] not null default '{}';
+alter table hivemind_queries add column if not exists winner       text not null default '';
+alter table hivemind_queries add column if not exists winner_index integer not null default -1;
+alter table hivemind_queries add column if not exists has_error    boolean not null default false;
+alter table hivemind_queries add column if not exists result_count integer not null default 0;
+alter table hivemind_queries add column if not exists search_text  text not null default '';
+
+-- Trigram index so case-insensitive substring search (ILIKE) stays fast
+create extension if not exists pg_trgm;
+
+create index if not exists hivemind_queries_created_at_idx on hivemind_queries (created_at desc);
+create index if not exists hivemind_queries_search_text_idx on hivemind_queries using gin (search_text gin_trgm_ops);
+create index if not exists hivemind_queries_agents_idx on hivemind_queries using gin (agents);
+create index if not exists hivemind_queries_models_idx on hivemind_queries using gin (models);
+
+create table if not exists hivemind_results (
+    query_id        text not null references hivemind_queries (query_id) on delete cascade,
+    result_index    integer not null,
+    model           text not null,
+    output          text not null default '',
+    error           text not null default '',
+    confidence      double precision not null default 0,
+    processing_time bigint not null default 0,
+    worker_params   jsonb,
+    is_best         boolean not null default false,
+    primary key (query_id, result_index)
+);
+
+-- Saves a query and its results in one transaction. Saving an existing id replaces
+-- the query row and its results, keeping votes and feedback that reference it.
+create or replace function hivemind_save_query(query_row jsonb, result_rows jsonb)
+returns void
+language plpgsql
+as $$
+begin
+    insert into hivemind_queries (query_id, created_at, query, request, response, prompts,
+        agents, models, winner, winner_index, has_error, result_count, search_text)
+    select q.query_id, q.created_at, q.query, q.request, q.response, coalesce(q.prompts, '[]'::jsonb),
+        coalesce(q.agents, '{}'), coalesce(q.models, '{}'), coalesce(q.winner, ''), q.winner_index,
+        q.has_error, q.result_count, coalesce(q.search_text, '')
+    from jsonb_populate_record(null::hivemind_queries, query_row) as q
+    on conflict (query_id) do update set
+        created_at   = excluded.created_at,
+        query        = excluded.query,
+        request      = excluded.request,
+        response     = excluded.response,
+        prompts      = excluded.prompts,
+        agents       = excluded.agents,
+        models       = excluded.models,
+        winner       = excluded.winner,
+        winner_index = excluded.winner_index,
+        has_error    = excluded.has_error,
+        result_count = excluded.result_count,
+        search_text  = excluded.search_text;
+
+    delete from hivemind_results where query_id = query_row->>'query_id';
+
+    insert into hivemind_results (query_id, result_index, model, output, error, confidence,
+        processing_time, worker_params, is_best)
+    select r.query_id, r.result_index, r.model, coalesce(r.output, ''), coalesce(r.error, ''),
+        r.confidence, r.processing_time, r.worker_params, r.is_best
+    from jsonb_populate_recordset(null::hivemind_results, result_rows) as r;
+end;
+$$;
+
+create table if not exists hivemind_votes (
+    id         bigint generated always as identity primary key,
+    query_id   text not null references hivemind_queries (query_id) on delete cascade,
+    best_index integer not null,
+    rubric     text not null,
+    agrees     boolean not null,
+    decision   jsonb not null,
+    created_at timestamptz not null default now()
+);
+
+create index if not exists hivemind_votes_query_id_idx on hivemind_votes (query_id);
+
+create table if not exists hivemind_feedback (
+    id         bigint generated always as identity primary key,
+    query_id   text not null references hivemind_queries (query_id) on delete cascade,
+    best_index integer not null,
+    agents     text[] not null,
+    responses  jsonb not null default '[]'::jsonb,
+    comment    text not null default '',
+    rubric     text not null default '',
+    created_at timestamptz not null default now()
+);
+
+create index if not exists hivemind_feedback_query_id_idx on hivemind_feedback (query_id);
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Token counts in the OpenAI usage format; Estimated is set when any count was approximated
+type TokenUsage struct {
+	PromptTokens     int  `json:"prompt_tokens"`
+	CompletionTokens int  `json:"completion_tokens"`
+	TotalTokens      int  `json:"total_tokens"`
+	Estimated        bool `json:"estimated,omitempty"`
+}
+
+func (u *TokenUsage) add(other *TokenUsage) {
+	if other == nil {
+		return
+	}
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.Estimated = u.Estimated || other.Estimated
+}
+
+type usageContextKey struct{}
+
+// Running total of every model call made with a context
+type usageTracker struct {
+	mu    sync.Mutex
+	usage TokenUsage
+}
+
+func withUsageTracking(ctx context.Context) (context.Context, *usageTracker) {
+	tracker := &usageTracker{}
+	return context.WithValue(ctx, usageContextKey{}, tracker), tracker
+}
+
+func recordUsage(ctx context.Context, usage *TokenUsage) {
+	if tracker, ok := ctx.Value(usageContextKey{}).(*usageTracker); ok {
+		tracker.mu.Lock()
+		tracker.usage.add(usage)
+		tracker.mu.Unlock()
+	}
+}
+
+func (t *usageTracker) total() *TokenUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := t.usage
+	return &usage
+}
+
+// Approximate usage from text length for servers that do not report it
+func estimateUsage(prompt string, resp QwenResponse) *TokenUsage {
+	completion := 0
+	if len(resp.Choices) > 0 {
+		completion = estimateTokens(resp.Choices[0].Message.Content)
+	}
+	promptTokens := estimateTokens(prompt)
+	return &TokenUsage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completion,
+		TotalTokens:      promptTokens + completion,
+		Estimated:        true,
+	}
+}
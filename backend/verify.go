@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Kinds of automatic checks run against response content
+const (
+	VerifyGo         = "go"
+	VerifyJSON       = "json"
+	VerifyYAML       = "yaml"
+	VerifyTOML       = "toml"
+	VerifyJSONSchema = "json_schema"
+)
+
+// Ranking score multiplier applied to responses that fail verification
+const VerificationFailurePenalty = 0.5
+
+// Maximum diagnostics kept per check
+const maxDiagnostics = 5
+
+// Outcome of every automatic check run against one response
+type VerificationReport struct {
+	Passed bool                `json:"passed"`
+	Checks []VerificationCheck `json:"checks"`
+}
+
+// An inconclusive check could not finish; it neither passes nor fails the response
+type VerificationCheck struct {
+	Kind         string   `json:"kind"`
+	Source       string   `json:"source"`
+	Passed       bool     `json:"passed"`
+	Inconclusive bool     `json:"inconclusive,omitempty"`
+	Diagnostics  []string `json:"diagnostics,omitempty"`
+}
+
+func (c VerificationCheck) failed() bool {
+	return !c.Passed && !c.Inconclusive
+}
+
+// A fenced code block or structured payload pulled out of a response
+type extractedBlock struct {
+	Language string
+	Content  string
+	Source   string
+}
+
+var fencedBlockPattern = regexp.MustCompile("(?s)```([A-Za-z0-9_+-]*)[^\\n]*\\n(.*?)```")
+
+// Longest time type-checking may take; syntax is always checked
+const GoTypeCheckTimeout = 5 * time.Second
+
+// Extract fenced code blocks, plus the whole output when it is itself a JSON payload
+func extractBlocks(output string) []extractedBlock {
+	blocks := make([]extractedBlock, 0)
+
+	for i, match := range fencedBlockPattern.FindAllStringSubmatch(output, -1) {
+		language := normalizeLanguage(match[1], match[2])
+		if language == "" {
+			continue
+		}
+		blocks = append(blocks, extractedBlock{
+			Language: language,
+			Content:  match[2],
+			Source:   fmt.Sprintf("code block %d", i+1),
+		})
+	}
+
+	trimmed := strings.TrimSpace(output)
+	if len(blocks) == 0 && (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) {
+		blocks = append(blocks, extractedBlock{
+			Language: VerifyJSON,
+			Content:  trimmed,
+			Source:   "output",
+		})
+	}
+
+	return blocks
+}
+
+// Map a fence label to a verifiable language; unlabeled blocks are checked as JSON if they look like it
+func normalizeLanguage(label, content string) string {
+	switch strings.ToLower(label) {
+	case "go", "golang":
+		return VerifyGo
+	case "json":
+		return VerifyJSON
+	case "yaml", "yml":
+		return VerifyYAML
+	case "toml":
+		return VerifyTOML
+	case "":
+		trimmed := strings.TrimSpace(content)
+		if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			return VerifyJSON
+		}
+	}
+	return ""
+}
+
+// Run every applicable check against a response. Returns nil when there is nothing to verify.
+func verifyOutput(output string, schema map[string]interface{}) *VerificationReport {
+	blocks := extractBlocks(output)
+	report := &VerificationReport{Passed: true, Checks: make([]VerificationCheck, 0)}
+	jsonPayloads := 0
+
+	for _, block := range blocks {
+		var check VerificationCheck
+		switch block.Language {
+		case VerifyGo:
+			check = verifyGo(block.Content)
+		case VerifyJSON:
+			check = verifyJSON(block.Content)
+		case VerifyYAML:
+			check = verifyYAML(block.Content)
+		case VerifyTOML:
+			check = verifyTOML(block.Content)
+		}
+		check.Source = block.Source
+		report.Checks = append(report.Checks, check)
+
+		if block.Language == VerifyJSON && schema != nil && check.Passed {
+			jsonPayloads++
+			schemaCheck := verifyJSONSchema(block.Content, schema)
+			schemaCheck.Source = block.Source
+			report.Checks = append(report.Checks, schemaCheck)
+		}
+	}
+
+	// A schema was requested, so an answer without any valid JSON fails it
+	if schema != nil && jsonPayloads == 0 {
+		report.Checks = append(report.Checks, VerificationCheck{
+			Kind:        VerifyJSONSchema,
+			Source:      "output",
+			Passed:      false,
+			Diagnostics: []string{"no valid JSON payload found to validate against the schema"},
+		})
+	}
+
+	if len(report.Checks) == 0 {
+		return nil
+	}
+
+	for _, check := range report.Checks {
+		if check.failed() {
+			report.Passed = false
+		}
+	}
+
+	return report
+}
+
+// Attach verification reports to every successful result
+func verifyResults(results []AIResult, schema map[string]interface{}) {
+	for i := range results {
+		if results[i].Error != "" || strings.TrimSpace(results[i].Output) == "" {
+			continue
+		}
+		results[i].Verification = verifyOutput(results[i].Output, schema)
+	}
+}
+
+// Parse and type-check Go. Complete files are type-checked; fragments without a
+// package clause are wrapped and only checked for syntax.
+func verifyGo(src string) VerificationCheck {
+	check := VerificationCheck{Kind: VerifyGo, Passed: true}
+
+	// A fresh file set per snippet, so nothing accumulates across verifications
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "snippet.go", src, parser.AllErrors)
+	if err != nil && !hasPackageClause(src) {
+		// Try as top-level declarations, then as statements inside a function body
+		if _, declErr := parser.ParseFile(token.NewFileSet(), "snippet.go", "package snippet\n"+src, parser.AllErrors); declErr == nil {
+			return check
+		}
+		if _, stmtErr := parser.ParseFile(token.NewFileSet(), "snippet.go", "package snippet\nfunc _() {\n"+src+"\n}", parser.AllErrors); stmtErr == nil {
+			return check
+		}
+	}
+	if err != nil {
+		check.Passed = false
+		check.Diagnostics = limitDiagnostics(strings.Split(err.Error(), "\n"))
+		return check
+	}
+
+	// Type-check in the background and give up on it, not on the answer, if it is slow.
+	// Each call gets its own importer, which is not safe for concurrent use, so one
+	// slow snippet never holds up another's deadline.
+	done := make(chan []string, 1)
+	go func() {
+		var typeErrors []string
+		conf := types.Config{
+			Importer: importer.Default(),
+			Error: func(err error) {
+				// Imports that cannot be resolved here are not counted against the answer
+				if strings.Contains(err.Error(), "could not import") {
+					return
+				}
+				typeErrors = append(typeErrors, err.Error())
+			},
+		}
+		conf.Check(file.Name.Name, fileSet, []*ast.File{file}, nil)
+		done <- typeErrors
+	}()
+
+	var typeErrors []string
+	select {
+	case typeErrors = <-done:
+	case <-time.After(GoTypeCheckTimeout):
+		check.Passed = false
+		check.Inconclusive = true
+		check.Diagnostics = []string{fmt.Sprintf("type-check timed out after %s", GoTypeCheckTimeout)}
+		return check
+	}
+
+	if len(typeErrors) > 0 {
+		check.Passed = false
+		check.Diagnostics = limitDiagnostics(typeErrors)
+	}
+
+	return check
+}
+
+func hasPackageClause(src string) bool {
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		return strings.HasPrefix(line, "package ")
+	}
+	return false
+}
+
+func verifyJSON(src string) VerificationCheck {
+	check := VerificationCheck{Kind: VerifyJSON, Passed: true}
+
+	var payload interface{}
+	decoder := json.NewDecoder(strings.NewReader(src))
+	if err := decoder.Decode(&payload); err != nil {
+		check.Passed = false
+		check.Diagnostics = []string{describeJSONError(src, err)}
+		return check
+	}
+	if decoder.More() {
+		check.Passed = false
+		check.Diagnostics = []string{"unexpected content after JSON value"}
+	}
+
+	return check
+}
+
+// Add line and column to JSON syntax errors
+func describeJSONError(src string, err error) string {
+	syntaxErr, ok := err.(*json.SyntaxError)
+	if !ok {
+		return err.Error()
+	}
+	prefix := src[:min(int(syntaxErr.Offset), len(src))]
+	line := strings.Count(prefix, "\n") + 1
+	column := len(prefix) - strings.LastIndex(prefix, "\n")
+	return fmt.Sprintf("line %d, column %d: %v", line, column, err)
+}
+
+func verifyYAML(src string) VerificationCheck {
+	check := VerificationCheck{Kind: VerifyYAML, Passed: true}
+
+	decoder := yaml.NewDecoder(bytes.NewBufferString(src))
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, io.EOF) {
+			check.Passed = false
+			check.Diagnostics = []string{err.Error()}
+		}
+		break
+	}
+
+	return check
+}
+
+func verifyTOML(src string) VerificationCheck {
+	check := VerificationCheck{Kind: VerifyTOML, Passed: true}
+
+	var doc map[string]interface{}
+	if err := toml.Unmarshal([]byte(src), &doc); err != nil {
+		check.Passed = false
+		if decodeErr, ok := err.(*toml.DecodeError); ok {
+			row, column := decodeErr.Position()
+			check.Diagnostics = []string{fmt.Sprintf("line %d, column %d: %s", row, column, decodeErr.Error())}
+		} else {
+			check.Diagnostics = []string{err.Error()}
+		}
+	}
+
+	return check
+}
+
+func verifyJSONSchema(src string, schema map[string]interface{}) VerificationCheck {
+	check := VerificationCheck{Kind: VerifyJSONSchema, Passed: true}
+
+	var payload interface{}
+	if err := json.Unmarshal([]byte(src), &payload); err != nil {
+		check.Passed = false
+		check.Diagnostics = []string{err.Error()}
+		return check
+	}
+
+	if violations := validateJSONSchema(payload, schema, "$"); len(violations) > 0 {
+		check.Passed = false
+		check.Diagnostics = limitDiagnostics(violations)
+	}
+
+	return check
+}
+
+func limitDiagnostics(diagnostics []string) []string {
+	kept := make([]string, 0, maxDiagnostics)
+	for _, diagnostic := range diagnostics {
+		if strings.TrimSpace(diagnostic) == "" {
+			continue
+		}
+		if len(kept) == maxDiagnostics {
+			kept = append(kept, fmt.Sprintf("... and %d more", len(diagnostics)-maxDiagnostics))
+			break
+		}
+		kept = append(kept, diagnostic)
+	}
+	return kept
+}
+
+// Fraction of conclusive checks passed; responses with nothing to verify are not penalized
+func calculateVerificationScore(report *VerificationReport) float64 {
+	if report == nil {
+		return 1.0
+	}
+
+	passed, conclusive := 0, 0
+	for _, check := range report.Checks {
+		if check.Inconclusive {
+			continue
+		}
+		conclusive++
+		if check.Passed {
+			passed++
+		}
+	}
+	if conclusive == 0 {
+		return 1.0
+	}
+	return float64(passed) / float64(conclusive)
+}
+
+// Short verification note for the judge prompt
+func summarizeVerification(report *VerificationReport) string {
+	if report == nil {
+		return ""
+	}
+
+	failures := make([]string, 0)
+	inconclusive := make([]string, 0)
+	for _, check := range report.Checks {
+		if check.Passed {
+			continue
+		}
+		note := fmt.Sprintf("%s in %s", check.Kind, check.Source)
+		if len(check.Diagnostics) > 0 {
+			note += ": " + check.Diagnostics[0]
+		}
+		if check.Inconclusive {
+			inconclusive = append(inconclusive, note)
+		} else {
+			failures = append(failures, note)
+		}
+	}
+
+	var summary string
+	if report.Passed {
+		summary = fmt.Sprintf("passed %d automatic checks", len(report.Checks)-len(inconclusive))
+	} else {
+		summary = "FAILED automatic checks (" + strings.Join(failures, "; ") + ")"
+	}
+	if len(inconclusive) > 0 {
+		summary += "; inconclusive (" + strings.Join(inconclusive, "; ") + ")"
+	}
+	return summary
+}
+
+// Whether any check on a response could not finish
+func verificationInconclusive(report *VerificationReport) bool {
+	if report == nil {
+		return false
+	}
+	for _, check := range report.Checks {
+		if check.Inconclusive {
+			return true
+		}
+	}
+	return false
+}
+
+// Push responses that failed verification down the master's ranking. If the
+// judge's pick failed and a passing response exists, the best passing one wins.
+func applyVerificationPenalties(evaluation *MasterEvaluation, responses []AIResult) {
+	if evaluation == nil || len(evaluation.Rankings) == 0 {
+		return
+	}
+
+	failed := func(index int) bool {
+		return index >= 0 && index < len(responses) &&
+			responses[index].Verification != nil && !responses[index].Verification.Passed
+	}
+
+	penalized := false
+	for i := range evaluation.Rankings {
+		index := evaluation.Rankings[i].Index
+		if failed(index) {
+			evaluation.Rankings[i].Score *= VerificationFailurePenalty
+			evaluation.Rankings[i].Reasoning += " (penalized: failed automatic verification)"
+			penalized = true
+		} else if index >= 0 && index < len(responses) && verificationInconclusive(responses[index].Verification) {
+			// Not penalized, but say so rather than letting a timeout read as a pass
+			evaluation.Rankings[i].Reasoning += " (not penalized: automatic verification was inconclusive)"
+		}
+	}
+	if !penalized {
+		return
+	}
+
+	sortRankingsByScore(evaluation.Rankings)
+
+	top := evaluation.Rankings[0].Index
+	if failed(evaluation.BestResponseIndex) && !failed(top) {
+		evaluation.Reasoning = fmt.Sprintf("%s [Overridden: the judge's pick failed automatic verification, so %s was chosen instead]",
+			evaluation.Reasoning, responses[top].Model)
+		evaluation.BestResponseIndex = top
+	}
+}
+
+// Stable sort, highest score first
+func sortRankingsByScore(rankings []ResponseRanking) {
+	for i := 1; i < len(rankings); i++ {
+		for j := i; j > 0 && rankings[j].Score > rankings[j-1].Score; j-- {
+			rankings[j], rankings[j-1] = rankings[j-1], rankings[j]
+		}
+	}
+}